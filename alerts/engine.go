@@ -0,0 +1,414 @@
+package alerts
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stupidrun/mon/models"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+var ErrRuleNotFound = errors.New("rule not found")
+
+// Alert is the state of one rule on one host. It becomes pending when the
+// condition first holds, firing once it held for the rule's for-duration,
+// and resolved when it stops holding while firing. Times are taken from the
+// metric timestamps.
+type Alert struct {
+	RuleID      string  `json:"rule_id"`
+	RuleName    string  `json:"rule_name"`
+	Host        string  `json:"host"`
+	Severity    string  `json:"severity"`
+	State       string  `json:"state"`
+	Value       float64 `json:"value"`
+	Threshold   float64 `json:"threshold"`
+	ActiveSince int64   `json:"active_since"`
+	FiredAt     int64   `json:"fired_at,omitempty"`
+	ResolvedAt  int64   `json:"resolved_at,omitempty"`
+}
+
+// RuleStore keeps the rules in order, one JSON document per rule.
+// models.SQLiteStore implements it, and NewRuleFile keeps them in a file.
+type RuleStore interface {
+	LoadAlertRules() ([]json.RawMessage, error)
+	SaveAlertRules(rules []json.RawMessage) error
+}
+
+type ruleFile string
+
+// NewRuleFile keeps the rules as a JSON array in path, which can be edited
+// before startup. A missing file means no rules.
+func NewRuleFile(path string) RuleStore {
+	return ruleFile(path)
+}
+
+func (f ruleFile) LoadAlertRules() ([]json.RawMessage, error) {
+	data, err := os.ReadFile(string(f))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var rules []json.RawMessage
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (f ruleFile) SaveAlertRules(rules []json.RawMessage) error {
+	if rules == nil {
+		rules = []json.RawMessage{}
+	}
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	return models.WriteFileAtomic(string(f), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// hostAlerts holds the alerts of one host by rule ID. Each host has its own
+// lock so that pushes from different hosts are evaluated in parallel.
+type hostAlerts struct {
+	mu     sync.Mutex
+	alerts map[string]*Alert
+}
+
+// Engine evaluates the rules against every accepted metric. Rules are read
+// from a RuleStore at startup and written back on every change made through
+// the API.
+type Engine struct {
+	// mu guards the rules slice, which is replaced rather than modified,
+	// the hosts map and the listeners.
+	mu        sync.RWMutex
+	rules     []*compiled
+	hosts     map[string]*hostAlerts
+	listeners []func(Alert)
+
+	// saveMu serialises rule changes, which are saved before they are
+	// published to Observe.
+	saveMu sync.Mutex
+	store  RuleStore
+}
+
+// NewEngine loads the rules kept in store.
+func NewEngine(store RuleStore) (*Engine, error) {
+	e := &Engine{store: store, hosts: make(map[string]*hostAlerts)}
+	docs, err := store.LoadAlertRules()
+	if err != nil {
+		return nil, err
+	}
+
+	assigned := false
+	for _, doc := range docs {
+		var r Rule
+		if err := json.Unmarshal(doc, &r); err != nil {
+			return nil, err
+		}
+		if r.ID == "" {
+			r.ID = newID()
+			assigned = true
+		}
+		c, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		e.rules = append(e.rules, c)
+	}
+	// Hand-written rules get their IDs written back so that they stay the
+	// same across restarts.
+	if assigned {
+		if err := e.save(e.rules); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func newID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// OnTransition registers fn to be called, outside the engine locks, every
+// time an alert changes state.
+func (e *Engine) OnTransition(fn func(Alert)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, fn)
+}
+
+func (e *Engine) notify(changed []Alert) {
+	if len(changed) == 0 {
+		return
+	}
+	e.mu.RLock()
+	listeners := slices.Clone(e.listeners)
+	e.mu.RUnlock()
+	for _, a := range changed {
+		log.Printf("alert %s %s on %s: value %.2f, threshold %.2f", a.RuleName, a.State, a.Host, a.Value, a.Threshold)
+		for _, fn := range listeners {
+			fn(a)
+		}
+	}
+}
+
+// host returns the alert state of name, creating it if needed.
+func (e *Engine) host(name string) *hostAlerts {
+	e.mu.RLock()
+	h := e.hosts[name]
+	e.mu.RUnlock()
+	if h != nil {
+		return h
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	h = e.hosts[name]
+	if h == nil {
+		h = &hostAlerts{alerts: make(map[string]*Alert)}
+		e.hosts[name] = h
+	}
+	return h
+}
+
+// Observe evaluates every rule that applies to the host of m. Only the lock
+// of that host is held while the rules are evaluated.
+func (e *Engine) Observe(m models.Metric) {
+	var changed []Alert
+
+	h := e.host(m.Name)
+	h.mu.Lock()
+	// The rules are read under the host lock so that a rule change, which
+	// clears the alerts of a host under the same lock, cannot be overtaken
+	// by an evaluation of the old rules.
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+	for _, r := range rules {
+		if !r.appliesTo(m.Name) {
+			continue
+		}
+		value, active, ok := r.eval(m)
+		if !ok {
+			continue
+		}
+		a, exists := h.alerts[r.ID]
+		switch {
+		case active && (!exists || a.State == StateResolved):
+			a = &Alert{
+				RuleID:      r.ID,
+				RuleName:    r.Name,
+				Host:        m.Name,
+				Severity:    r.Severity,
+				State:       StatePending,
+				Value:       value,
+				Threshold:   r.Threshold,
+				ActiveSince: m.Timestamp,
+			}
+			h.alerts[r.ID] = a
+			changed = append(changed, *a)
+		case !active && exists && a.State == StatePending:
+			delete(h.alerts, r.ID)
+			continue
+		case !active && exists && a.State == StateFiring:
+			a.State = StateResolved
+			a.Value = value
+			a.ResolvedAt = m.Timestamp
+			changed = append(changed, *a)
+			continue
+		}
+		if !active {
+			continue
+		}
+		a.Value = value
+		if a.State == StatePending && m.Timestamp-a.ActiveSince >= r.forSec {
+			a.State = StateFiring
+			a.FiredAt = m.Timestamp
+			changed = append(changed, *a)
+		}
+	}
+	h.mu.Unlock()
+
+	e.notify(changed)
+}
+
+func (e *Engine) hostList() []*hostAlerts {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Collect(maps.Values(e.hosts))
+}
+
+// Alerts returns the alerts in state, or all of them if state is empty,
+// ordered by host and rule name.
+func (e *Engine) Alerts(state string) []Alert {
+	result := make([]Alert, 0)
+	for _, h := range e.hostList() {
+		h.mu.Lock()
+		for _, a := range h.alerts {
+			if state == "" || a.State == state {
+				result = append(result, *a)
+			}
+		}
+		h.mu.Unlock()
+	}
+	slices.SortFunc(result, func(a, b Alert) int {
+		if c := strings.Compare(a.Host, b.Host); c != 0 {
+			return c
+		}
+		return strings.Compare(a.RuleName, b.RuleName)
+	})
+	return result
+}
+
+// Forget drops the alerts of a host that is no longer monitored.
+func (e *Engine) Forget(host string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.hosts, host)
+}
+
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return ruleList(e.rules)
+}
+
+func ruleList(compiled []*compiled) []Rule {
+	rules := make([]Rule, len(compiled))
+	for i, r := range compiled {
+		rules[i] = r.Rule
+	}
+	return rules
+}
+
+func (e *Engine) Rule(id string) (Rule, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	i := index(e.rules, id)
+	if i < 0 {
+		return Rule{}, ErrRuleNotFound
+	}
+	return e.rules[i].Rule, nil
+}
+
+// AddRule validates r, assigns it a new ID and saves it.
+func (e *Engine) AddRule(r Rule) (Rule, error) {
+	r.ID = newID()
+	c, err := compile(r)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
+	e.mu.RLock()
+	rules := append(slices.Clone(e.rules), c)
+	e.mu.RUnlock()
+	if err := e.save(rules); err != nil {
+		return Rule{}, err
+	}
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+	return c.Rule, nil
+}
+
+// UpdateRule replaces the rule with the given ID. Its alerts start over and
+// firing ones are resolved.
+func (e *Engine) UpdateRule(id string, r Rule) (Rule, error) {
+	r.ID = id
+	c, err := compile(r)
+	if err != nil {
+		return Rule{}, err
+	}
+	if err := e.replace(id, c); err != nil {
+		return Rule{}, err
+	}
+	return c.Rule, nil
+}
+
+// DeleteRule removes a rule and resolves its firing alerts.
+func (e *Engine) DeleteRule(id string) error {
+	return e.replace(id, nil)
+}
+
+func (e *Engine) replace(id string, c *compiled) error {
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
+	e.mu.RLock()
+	rules := slices.Clone(e.rules)
+	e.mu.RUnlock()
+	i := index(rules, id)
+	if i < 0 {
+		return ErrRuleNotFound
+	}
+	if c != nil {
+		rules[i] = c
+	} else {
+		rules = slices.Delete(rules, i, i+1)
+	}
+	if err := e.save(rules); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+
+	// Observe reads the rules under the host lock, so once the alerts of a
+	// host are cleared here no evaluation of the old rules can follow.
+	var changed []Alert
+	now := time.Now().UTC().Unix()
+	for _, h := range e.hostList() {
+		h.mu.Lock()
+		if a, ok := h.alerts[id]; ok {
+			if a.State == StateFiring {
+				a.State = StateResolved
+				a.ResolvedAt = now
+				changed = append(changed, *a)
+			}
+			delete(h.alerts, id)
+		}
+		h.mu.Unlock()
+	}
+
+	e.notify(changed)
+	return nil
+}
+
+func index(rules []*compiled, id string) int {
+	return slices.IndexFunc(rules, func(c *compiled) bool { return c.ID == id })
+}
+
+// save writes rules to the rule store.
+func (e *Engine) save(rules []*compiled) error {
+	docs := make([]json.RawMessage, len(rules))
+	for i, r := range rules {
+		doc, err := json.Marshal(r.Rule)
+		if err != nil {
+			return err
+		}
+		docs[i] = doc
+	}
+	return e.store.SaveAlertRules(docs)
+}
@@ -0,0 +1,80 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stupidrun/mon/models"
+	"sync"
+	"testing"
+)
+
+// memRules keeps the rules in memory.
+type memRules struct {
+	mu    sync.Mutex
+	rules []json.RawMessage
+}
+
+func (s *memRules) LoadAlertRules() ([]json.RawMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rules, nil
+}
+
+func (s *memRules) SaveAlertRules(rules []json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+	return nil
+}
+
+// A rule deleted while metrics are evaluated must not leave alerts behind,
+// and every alert that fired must be resolved.
+func TestDeleteRuleDuringObserve(t *testing.T) {
+	e, err := NewEngine(new(memRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	firing := make(map[string]int)
+	e.OnTransition(func(a Alert) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch a.State {
+		case StateFiring:
+			firing[a.RuleID+"/"+a.Host]++
+		case StateResolved:
+			firing[a.RuleID+"/"+a.Host]--
+		}
+	})
+
+	for round := range 500 {
+		r, err := e.AddRule(Rule{Name: "cpu", Field: "cpu", Op: ">", Threshold: 50})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				name := fmt.Sprintf("web-%02d", i%2)
+				for ts := range int64(20) {
+					e.Observe(models.Metric{Name: name, Timestamp: int64(round)*1000 + ts, CPUUsage: 90})
+				}
+			}()
+		}
+		if err := e.DeleteRule(r.ID); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+	}
+
+	if alerts := e.Alerts(""); len(alerts) != 0 {
+		t.Errorf("%d alerts left for deleted rules: %+v", len(alerts), alerts)
+	}
+	for key, n := range firing {
+		if n != 0 {
+			t.Errorf("%s fired %d more times than it was resolved", key, n)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package alerts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stupidrun/mon/models"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventOffline  = "offline"
+	EventOnline   = "online"
+	EventFiring   = "firing"
+	EventResolved = "resolved"
+)
+
+// Event is something worth telling a human about: a host going offline or
+// coming back, or an alert firing or resolving.
+type Event struct {
+	Kind     string `json:"kind"`
+	Host     string `json:"host"`
+	Time     int64  `json:"time"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	LastSeen int64  `json:"last_seen,omitempty"`
+	Alert    *Alert `json:"alert,omitempty"`
+	Silenced bool   `json:"silenced,omitempty"`
+}
+
+// AlertEvent turns a firing or resolved alert into an event. Pending alerts
+// are not worth an event.
+func AlertEvent(a Alert) (Event, bool) {
+	e := Event{Host: a.Host, Severity: a.Severity, Alert: &a}
+	switch a.State {
+	case StateFiring:
+		e.Kind, e.Time = EventFiring, a.FiredAt
+		e.Message = fmt.Sprintf("%s is firing on %s: value %.2f, threshold %.2f", a.RuleName, a.Host, a.Value, a.Threshold)
+	case StateResolved:
+		e.Kind, e.Time = EventResolved, a.ResolvedAt
+		e.Message = fmt.Sprintf("%s is resolved on %s: value %.2f", a.RuleName, a.Host, a.Value)
+	default:
+		return Event{}, false
+	}
+	return e, true
+}
+
+// Notifier delivers events to people, e.g. by chat or mail.
+type Notifier interface {
+	Notify(e Event) error
+}
+
+// LogNotifier writes events to the server log.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(e Event) error {
+	log.Printf("event %s %s: %s", e.Kind, e.Host, e.Message)
+	return nil
+}
+
+// EventFilter selects events from the history. Zero fields match
+// everything.
+type EventFilter struct {
+	Kind  string
+	Host  string
+	From  int64
+	To    int64
+	Limit int
+}
+
+func (f EventFilter) match(e Event) bool {
+	return (f.Kind == "" || e.Kind == f.Kind) &&
+		(f.Host == "" || e.Host == f.Host) &&
+		(f.From == 0 || e.Time >= f.From) &&
+		(f.To == 0 || e.Time <= f.To)
+}
+
+// History keeps the most recent events in memory and appends every event to
+// a JSON-lines file, from which it is reloaded on startup. The file is
+// rewritten with only the kept events whenever it grows to twice the limit.
+type History struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	limit  int
+	lines  int
+	events []Event
+}
+
+func OpenHistory(path string, limit int) (*History, error) {
+	h := &History{path: path, limit: limit}
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	if h.lines > h.limit {
+		if err := h.compact(); err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	h.f = f
+	return h, nil
+}
+
+func (h *History) load() error {
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		h.lines++
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		h.append(e)
+	}
+	return scanner.Err()
+}
+
+func (h *History) append(e Event) {
+	h.events = append(h.events, e)
+	if len(h.events) > 2*h.limit {
+		h.events = slices.Clone(h.events[len(h.events)-h.limit:])
+	}
+}
+
+// compact rewrites the file with the kept events and reopens it for
+// appending. Callers must hold h.mu, except during OpenHistory.
+func (h *History) compact() error {
+	keep := h.events[max(0, len(h.events)-h.limit):]
+	err := models.WriteFileAtomic(h.path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, e := range keep {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if h.f != nil {
+		h.f.Close()
+	}
+	h.f = f
+	h.events = slices.Clone(keep)
+	h.lines = len(keep)
+	return nil
+}
+
+func (h *History) Add(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(e)
+	if _, err := h.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	h.lines++
+	if h.lines > 2*h.limit {
+		return h.compact()
+	}
+	return nil
+}
+
+// Query returns the matching events, oldest first. With a limit only the
+// most recent ones are returned.
+func (h *History) Query(filter EventFilter) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	start := max(0, len(h.events)-h.limit)
+	result := make([]Event, 0)
+	for _, e := range h.events[start:] {
+		if filter.match(e) {
+			result = append(result, e)
+		}
+	}
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[len(result)-filter.Limit:]
+	}
+	return result
+}
+
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.f.Close()
+}
+
+// Dispatcher records events in the history and hands them to the notifiers,
+// each on its own goroutine, so a slow notifier never blocks ingest or the
+// other notifiers. Events
+// muted by the silencer are recorded as silenced and not sent.
+type Dispatcher struct {
+	history  *History
+	silencer *Silencer
+	workers  []*notifyWorker
+	wg       sync.WaitGroup
+
+	// mu guards closed; Publish holds it shared so that Close cannot close
+	// the queues under a send.
+	mu     sync.RWMutex
+	closed bool
+
+	// held keeps the offline and firing events that were muted, by host and
+	// rule, until the event ending them is published. Recheck sends them
+	// if their silence ends first.
+	heldMu sync.Mutex
+	held   map[string]Event
+}
+
+// notifyWorker sends the events queued for one notifier, so that a slow or
+// failing notifier only delays its own events.
+type notifyWorker struct {
+	name     string
+	notifier Notifier
+	queue    chan Event
+}
+
+// dispatchQueueSize bounds the events waiting for each notifier; further
+// events are still recorded but not sent to that notifier.
+const dispatchQueueSize = 1024
+
+// NewDispatcher returns a running dispatcher. silencer may be nil.
+func NewDispatcher(history *History, silencer *Silencer, notifiers ...Notifier) *Dispatcher {
+	d := &Dispatcher{
+		history:  history,
+		silencer: silencer,
+		held:     make(map[string]Event),
+	}
+	for _, n := range notifiers {
+		w := &notifyWorker{name: fmt.Sprintf("%T", n), notifier: n, queue: make(chan Event, dispatchQueueSize)}
+		if named, ok := n.(interface{ Name() string }); ok {
+			w.name = named.Name()
+		}
+		d.workers = append(d.workers, w)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			w.run()
+		}()
+	}
+	return d
+}
+
+func (w *notifyWorker) run() {
+	for e := range w.queue {
+		if err := w.notifier.Notify(e); err != nil {
+			log.Printf("Failed to send %s event of %s: %v", e.Kind, e.Host, err)
+		}
+	}
+}
+
+// Publish records e and queues it for every notifier. Events published
+// after Close are dropped.
+func (d *Dispatcher) Publish(e Event) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		log.Printf("Dispatcher closed, dropping %s event of %s", e.Kind, e.Host)
+		return
+	}
+	if e.Time == 0 {
+		e.Time = time.Now().UTC().Unix()
+	}
+	e.Silenced = d.silencer != nil && d.silencer.Muted(e)
+	d.hold(e)
+	if err := d.history.Add(e); err != nil {
+		log.Printf("Failed to record %s event of %s: %v", e.Kind, e.Host, err)
+	}
+	if e.Silenced {
+		log.Printf("event %s %s silenced: %s", e.Kind, e.Host, e.Message)
+		return
+	}
+	for _, w := range d.workers {
+		select {
+		case w.queue <- e:
+		default:
+			log.Printf("Notification queue of %s full, dropping %s event of %s", w.name, e.Kind, e.Host)
+		}
+	}
+}
+
+func heldKey(e Event) string {
+	if e.Alert != nil {
+		return e.Alert.RuleID + "\x00" + e.Host
+	}
+	return "\x00" + e.Host
+}
+
+// hold remembers e if it is a muted offline or firing event, and forgets
+// what was held for the same host and rule otherwise.
+func (d *Dispatcher) hold(e Event) {
+	d.heldMu.Lock()
+	defer d.heldMu.Unlock()
+	if e.Silenced && (e.Kind == EventOffline || e.Kind == EventFiring) {
+		d.held[heldKey(e)] = e
+	} else {
+		delete(d.held, heldKey(e))
+	}
+}
+
+// Recheck publishes again the held events that are no longer muted at now,
+// so that a host still offline or an alert still firing when its silence
+// or maintenance window ends is announced then.
+func (d *Dispatcher) Recheck(now time.Time) {
+	if d.silencer == nil {
+		return
+	}
+	var due []Event
+	d.heldMu.Lock()
+	for key, e := range d.held {
+		e.Time = now.Unix()
+		e.Silenced = false
+		if !d.silencer.Muted(e) {
+			due = append(due, e)
+			delete(d.held, key)
+		}
+	}
+	d.heldMu.Unlock()
+
+	slices.SortFunc(due, func(a, b Event) int { return strings.Compare(heldKey(a), heldKey(b)) })
+	for _, e := range due {
+		log.Printf("event %s %s no longer silenced, sending it now", e.Kind, e.Host)
+		d.Publish(e)
+	}
+}
+
+// Run rechecks the held events every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.Recheck(time.Now().UTC())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Forget drops the held events of a host that is no longer monitored.
+func (d *Dispatcher) Forget(host string) {
+	d.heldMu.Lock()
+	defer d.heldMu.Unlock()
+	for key, e := range d.held {
+		if e.Host == host {
+			delete(d.held, key)
+		}
+	}
+}
+
+// Close stops accepting events and waits for the queued ones to be sent.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	for _, w := range d.workers {
+		close(w.queue)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
@@ -0,0 +1,74 @@
+package alerts
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type notifierFunc func(e Event) error
+
+func (f notifierFunc) Notify(e Event) error { return f(e) }
+
+func TestDispatcherSlowNotifierDoesNotStallOthers(t *testing.T) {
+	history, err := OpenHistory(filepath.Join(t.TempDir(), "events.log"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	release := make(chan struct{})
+	stuck := notifierFunc(func(Event) error {
+		<-release
+		return nil
+	})
+	sent := make(chan Event, 10)
+	fast := notifierFunc(func(e Event) error {
+		sent <- e
+		return nil
+	})
+	d := NewDispatcher(history, nil, stuck, fast)
+	defer d.Close()
+	defer close(release)
+
+	for _, host := range []string{"web-01", "web-02", "web-03"} {
+		d.Publish(Event{Kind: EventOffline, Host: host})
+	}
+	for _, host := range []string{"web-01", "web-02", "web-03"} {
+		select {
+		case e := <-sent:
+			if e.Host != host {
+				t.Errorf("got event of %s, want %s", e.Host, host)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event of %s not sent while another notifier is stuck", host)
+		}
+	}
+}
+
+func TestDispatcherCloseSendsQueuedEvents(t *testing.T) {
+	history, err := OpenHistory(filepath.Join(t.TempDir(), "events.log"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	sent := make(chan Event, 10)
+	d := NewDispatcher(history, nil, notifierFunc(func(e Event) error {
+		time.Sleep(10 * time.Millisecond)
+		sent <- e
+		return nil
+	}))
+	d.Publish(Event{Kind: EventOffline, Host: "web-01"})
+	d.Publish(Event{Kind: EventOnline, Host: "web-01"})
+	d.Close()
+	d.Close()
+	d.Publish(Event{Kind: EventOffline, Host: "web-02"})
+
+	if n := len(sent); n != 2 {
+		t.Errorf("%d events sent, want 2", n)
+	}
+	if n := len(history.Query(EventFilter{})); n != 2 {
+		t.Errorf("%d events recorded, want 2", n)
+	}
+}
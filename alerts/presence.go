@@ -0,0 +1,121 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"github.com/stupidrun/mon/models"
+	"sync"
+	"time"
+)
+
+// Presence watches every allowed name and publishes an event when a host
+// stops pushing for longer than the offline threshold and when it comes
+// back. /api/alive computes the same thing on demand; Presence exists so
+// somebody is told without polling.
+//
+// Hosts in maintenance keep the state they had when it began, so a host that
+// is rebooted during the window raises nothing, while one that is still down
+// afterwards is reported then.
+type Presence struct {
+	store     models.Store
+	threshold int64
+	silencer  *Silencer
+	events    *Dispatcher
+
+	mu     sync.Mutex
+	online map[string]bool
+}
+
+// NewPresence returns an evaluator publishing to events. silencer may be
+// nil.
+func NewPresence(store models.Store, thresholdSec int, silencer *Silencer, events *Dispatcher) *Presence {
+	return &Presence{
+		store:     store,
+		threshold: int64(thresholdSec),
+		silencer:  silencer,
+		events:    events,
+	}
+}
+
+// Run evaluates the hosts every interval until ctx is done.
+func (p *Presence) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	p.Evaluate(time.Now().UTC())
+	for {
+		select {
+		case <-ticker.C:
+			p.Evaluate(time.Now().UTC())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Evaluate compares the state of every host at now with the previous
+// evaluation. The first evaluation only records a baseline, so a restart
+// does not report every host that was already down.
+func (p *Presence) Evaluate(now time.Time) {
+	names := p.store.GetAllowedNames()
+	current := make(map[string]bool, len(names))
+	lastSeen := make(map[string]int64, len(names))
+	for _, name := range names {
+		m, ok := p.store.Latest(name)
+		if ok {
+			lastSeen[name] = m.Timestamp
+		}
+		current[name] = ok && now.Unix()-m.Timestamp <= p.threshold
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous := p.online
+	if previous != nil && p.silencer != nil {
+		for _, name := range names {
+			if was, known := previous[name]; known && p.silencer.InMaintenance(name, now) {
+				current[name] = was
+			}
+		}
+	}
+	p.online = current
+	if previous == nil {
+		return
+	}
+
+	for _, name := range names {
+		// Names allowed since the last evaluation start from their current
+		// state as well.
+		was, known := previous[name]
+		if !known {
+			continue
+		}
+		switch {
+		case was && !current[name]:
+			p.events.Publish(Event{
+				Kind:     EventOffline,
+				Host:     name,
+				Time:     now.Unix(),
+				Severity: SeverityCritical,
+				Message:  offlineMessage(name, lastSeen[name], now),
+				LastSeen: lastSeen[name],
+			})
+		case !was && current[name]:
+			p.events.Publish(Event{
+				Kind:     EventOnline,
+				Host:     name,
+				Time:     now.Unix(),
+				Severity: SeverityInfo,
+				Message:  fmt.Sprintf("%s is back online", name),
+				LastSeen: lastSeen[name],
+			})
+		}
+	}
+}
+
+func offlineMessage(name string, lastSeen int64, now time.Time) string {
+	if lastSeen == 0 {
+		return fmt.Sprintf("%s went offline, it has never pushed", name)
+	}
+	silent := now.Sub(time.Unix(lastSeen, 0)).Truncate(time.Second)
+	return fmt.Sprintf("%s went offline, last seen %s ago", name, silent)
+}
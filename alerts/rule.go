@@ -0,0 +1,95 @@
+package alerts
+
+import (
+	"fmt"
+	"github.com/stupidrun/mon/models"
+	"slices"
+	"time"
+)
+
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var ops = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// Rule is a threshold on one field of the pushed metrics, such as
+// "cpu > 90 for 5m". Field accepts everything models.NewAggregation does;
+// when several labelled samples share the field name the highest value is
+// compared. Hosts limits the rule to some names, an empty list applies it
+// to every host.
+type Rule struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Field       string   `json:"field"`
+	Op          string   `json:"op"`
+	Threshold   float64  `json:"threshold"`
+	For         string   `json:"for,omitempty"`
+	Severity    string   `json:"severity"`
+	Hosts       []string `json:"hosts,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// compiled is a validated rule ready for evaluation.
+type compiled struct {
+	Rule
+	forSec int64
+	agg    *models.Aggregation
+	cmp    func(v, threshold float64) bool
+}
+
+func compile(r Rule) (*compiled, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("rule name cannot be empty")
+	}
+	cmp, ok := ops[r.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown operator: %s", r.Op)
+	}
+	agg, err := models.NewAggregation(r.Field, "max")
+	if err != nil {
+		return nil, err
+	}
+	var forDur time.Duration
+	if r.For != "" {
+		if forDur, err = models.ParseDuration(r.For); err != nil || forDur < 0 {
+			return nil, fmt.Errorf("invalid for duration: %s", r.For)
+		}
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return nil, fmt.Errorf("unknown severity: %s", r.Severity)
+	}
+	return &compiled{
+		Rule:   r,
+		forSec: int64(forDur / time.Second),
+		agg:    agg,
+		cmp:    cmp,
+	}, nil
+}
+
+func (c *compiled) appliesTo(host string) bool {
+	return len(c.Hosts) == 0 || slices.Contains(c.Hosts, host)
+}
+
+// eval returns the value of the rule field in m and whether the condition
+// holds. ok is false when m does not carry the field.
+func (c *compiled) eval(m models.Metric) (value float64, active, ok bool) {
+	value, ok = c.agg.Apply([]models.Metric{m})
+	if !ok {
+		return 0, false, false
+	}
+	return value, c.cmp(value, c.Threshold), true
+}
@@ -0,0 +1,415 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stupidrun/mon/models"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSilenceNotFound = errors.New("silence not found")
+	ErrWindowNotFound  = errors.New("maintenance window not found")
+)
+
+// expiredSilenceTTL is how long an expired silence stays listed.
+const expiredSilenceTTL = 24 * time.Hour
+
+// Matcher selects what a silence or maintenance window mutes. A host matches
+// if it is listed in Hosts or carries one of Tags; empty lists match every
+// host. Rules, by ID or name, limits muting to those alerts, so that
+// offline events are only muted by matchers without rules.
+type Matcher struct {
+	Hosts []string `json:"hosts,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Rules []string `json:"rules,omitempty"`
+}
+
+func (m Matcher) validate() error {
+	if len(m.Hosts) == 0 && len(m.Tags) == 0 && len(m.Rules) == 0 {
+		return errors.New("at least one of hosts, tags or rules is required")
+	}
+	return nil
+}
+
+func (m Matcher) matchHost(host string, tags []string) bool {
+	if len(m.Hosts) == 0 && len(m.Tags) == 0 {
+		return true
+	}
+	if slices.Contains(m.Hosts, host) {
+		return true
+	}
+	return slices.ContainsFunc(m.Tags, func(t string) bool { return slices.Contains(tags, t) })
+}
+
+// match reports whether the matcher mutes an event of host. a is the alert
+// behind the event, or nil for presence events.
+func (m Matcher) match(host string, tags []string, a *Alert) bool {
+	if !m.matchHost(host, tags) {
+		return false
+	}
+	if len(m.Rules) == 0 {
+		return true
+	}
+	return a != nil && (slices.Contains(m.Rules, a.RuleID) || slices.Contains(m.Rules, a.RuleName))
+}
+
+// Silence mutes matching events between StartsAt and EndsAt, in unix
+// seconds.
+type Silence struct {
+	ID string `json:"id"`
+	Matcher
+	StartsAt  int64  `json:"starts_at"`
+	EndsAt    int64  `json:"ends_at"`
+	Comment   string `json:"comment,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+func (s Silence) Active(at time.Time) bool {
+	return at.Unix() >= s.StartsAt && at.Unix() < s.EndsAt
+}
+
+// Window is a recurring maintenance window, e.g. every Sunday at 02:00 for
+// two hours. Days holds three-letter weekday names and defaults to every
+// day; Start is HH:MM in Timezone, which defaults to UTC.
+type Window struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Matcher
+	Days     []string `json:"days,omitempty"`
+	Start    string   `json:"start"`
+	Duration string   `json:"duration"`
+	Timezone string   `json:"timezone,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// schedule is a validated Window.
+type schedule struct {
+	Window
+	days         map[time.Weekday]bool
+	hour, minute int
+	start        time.Duration // since midnight, ignoring DST changes
+	dur          time.Duration
+	loc          *time.Location
+}
+
+func compileWindow(w Window) (*schedule, error) {
+	if w.Name == "" {
+		return nil, errors.New("window name cannot be empty")
+	}
+	if err := w.Matcher.validate(); err != nil {
+		return nil, err
+	}
+	s := &schedule{Window: w, loc: time.UTC}
+	if len(w.Days) > 0 {
+		s.days = make(map[time.Weekday]bool, len(w.Days))
+		for _, d := range w.Days {
+			wd, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return nil, fmt.Errorf("unknown day: %s", d)
+			}
+			s.days[wd] = true
+		}
+	}
+	hh, mm, ok := strings.Cut(w.Start, ":")
+	h, herr := strconv.Atoi(hh)
+	m, merr := strconv.Atoi(mm)
+	if !ok || herr != nil || merr != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return nil, fmt.Errorf("invalid start time: %s", w.Start)
+	}
+	s.hour, s.minute = h, m
+	s.start = time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	dur, err := models.ParseDuration(w.Duration)
+	if err != nil || dur <= 0 || dur > 7*24*time.Hour {
+		return nil, fmt.Errorf("invalid duration: %s", w.Duration)
+	}
+	s.dur = dur
+	if w.Timezone != "" {
+		if s.loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %s", w.Timezone)
+		}
+	}
+	return s, nil
+}
+
+// active reports whether at falls in an occurrence of the window. An
+// occurrence may have started on one of the previous days if the window is
+// long enough. Occurrences start at the wall clock time in the window's
+// timezone, also on days when DST changes.
+func (s *schedule) active(at time.Time) bool {
+	at = at.In(s.loc)
+	for back := 0; time.Duration(back)*24*time.Hour < s.start+s.dur+time.Hour; back++ {
+		begin := time.Date(at.Year(), at.Month(), at.Day()-back, s.hour, s.minute, 0, 0, s.loc)
+		if s.days != nil && !s.days[begin.Weekday()] {
+			continue
+		}
+		if !at.Before(begin) && at.Before(begin.Add(s.dur)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Silencer decides which events are muted by silences and maintenance
+// windows. It also keeps the tags of each host that they match on. All of
+// it is kept in a JSON file rewritten on every change.
+type Silencer struct {
+	mu       sync.Mutex
+	path     string
+	silences []Silence
+	windows  []*schedule
+	tags     map[string][]string
+}
+
+type silencerFile struct {
+	Silences []Silence           `json:"silences"`
+	Windows  []Window            `json:"windows"`
+	Tags     map[string][]string `json:"tags"`
+}
+
+// NewSilencer loads the silences kept in path. A missing file means none.
+func NewSilencer(path string) (*Silencer, error) {
+	s := &Silencer{path: path, tags: make(map[string][]string)}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	var f silencerFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	s.silences = f.Silences
+	for _, w := range f.Windows {
+		c, err := compileWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("window %s: %w", w.Name, err)
+		}
+		s.windows = append(s.windows, c)
+	}
+	if f.Tags != nil {
+		s.tags = f.Tags
+	}
+	return s, nil
+}
+
+// Muted reports whether e should not be sent to the notifiers.
+func (s *Silencer) Muted(e Event) bool {
+	at := time.Unix(e.Time, 0)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.muted(e.Host, e.Alert, at)
+}
+
+// InMaintenance reports whether host is entirely muted at the given time,
+// that is by a silence or window that is not limited to some rules.
+func (s *Silencer) InMaintenance(host string, at time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.muted(host, nil, at)
+}
+
+func (s *Silencer) muted(host string, a *Alert, at time.Time) bool {
+	tags := s.tags[host]
+	for _, sl := range s.silences {
+		if sl.Active(at) && sl.match(host, tags, a) {
+			return true
+		}
+	}
+	for _, w := range s.windows {
+		if w.match(host, tags, a) && w.active(at) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Silencer) Silences() []Silence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.silences)
+}
+
+// AddSilence validates sl, assigns it a new ID and saves it. A missing start
+// means now. Silences that expired a while ago are dropped at the same time.
+func (s *Silencer) AddSilence(sl Silence) (Silence, error) {
+	if err := sl.Matcher.validate(); err != nil {
+		return Silence{}, err
+	}
+	now := time.Now().UTC()
+	if sl.StartsAt == 0 {
+		sl.StartsAt = now.Unix()
+	}
+	if sl.EndsAt <= sl.StartsAt {
+		return Silence{}, errors.New("ends_at must be after starts_at")
+	}
+	sl.ID = newID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.silences
+	cutoff := now.Add(-expiredSilenceTTL).Unix()
+	s.silences = slices.DeleteFunc(slices.Clone(s.silences), func(o Silence) bool { return o.EndsAt < cutoff })
+	s.silences = append(s.silences, sl)
+	if err := s.save(); err != nil {
+		s.silences = old
+		return Silence{}, err
+	}
+	return sl, nil
+}
+
+// DeleteSilence removes a silence, ending it early.
+func (s *Silencer) DeleteSilence(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.silences, func(o Silence) bool { return o.ID == id })
+	if i < 0 {
+		return ErrSilenceNotFound
+	}
+	old := s.silences
+	s.silences = slices.Delete(slices.Clone(s.silences), i, i+1)
+	if err := s.save(); err != nil {
+		s.silences = old
+		return err
+	}
+	return nil
+}
+
+func (s *Silencer) Windows() []Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.windowList()
+}
+
+func (s *Silencer) windowList() []Window {
+	windows := make([]Window, len(s.windows))
+	for i, w := range s.windows {
+		windows[i] = w.Window
+	}
+	return windows
+}
+
+// AddWindow validates w, assigns it a new ID and saves it.
+func (s *Silencer) AddWindow(w Window) (Window, error) {
+	w.ID = newID()
+	c, err := compileWindow(w)
+	if err != nil {
+		return Window{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows = append(s.windows, c)
+	if err := s.save(); err != nil {
+		s.windows = s.windows[:len(s.windows)-1]
+		return Window{}, err
+	}
+	return c.Window, nil
+}
+
+// UpdateWindow replaces the window with the given ID.
+func (s *Silencer) UpdateWindow(id string, w Window) (Window, error) {
+	w.ID = id
+	c, err := compileWindow(w)
+	if err != nil {
+		return Window{}, err
+	}
+	if err := s.replaceWindow(id, c); err != nil {
+		return Window{}, err
+	}
+	return c.Window, nil
+}
+
+func (s *Silencer) DeleteWindow(id string) error {
+	return s.replaceWindow(id, nil)
+}
+
+func (s *Silencer) replaceWindow(id string, c *schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.windows, func(w *schedule) bool { return w.ID == id })
+	if i < 0 {
+		return ErrWindowNotFound
+	}
+	old := slices.Clone(s.windows)
+	if c != nil {
+		s.windows[i] = c
+	} else {
+		s.windows = slices.Delete(s.windows, i, i+1)
+	}
+	if err := s.save(); err != nil {
+		s.windows = old
+		return err
+	}
+	return nil
+}
+
+func (s *Silencer) Tags(host string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.tags[host])
+}
+
+// SetTags replaces the tags of host; an empty list removes them.
+func (s *Silencer) SetTags(host string, tags []string) error {
+	tags = slices.Compact(slices.Sorted(slices.Values(tags)))
+	tags = slices.DeleteFunc(tags, func(t string) bool { return t == "" })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, had := s.tags[host]
+	if len(tags) == 0 {
+		delete(s.tags, host)
+	} else {
+		s.tags[host] = tags
+	}
+	if err := s.save(); err != nil {
+		if had {
+			s.tags[host] = old
+		} else {
+			delete(s.tags, host)
+		}
+		return err
+	}
+	return nil
+}
+
+// Forget drops the tags of a host that is no longer monitored.
+func (s *Silencer) Forget(host string) error {
+	if len(s.Tags(host)) == 0 {
+		return nil
+	}
+	return s.SetTags(host, nil)
+}
+
+// save writes the file through a synced temporary file. Callers must hold
+// s.mu.
+func (s *Silencer) save() error {
+	data, err := json.MarshalIndent(silencerFile{
+		Silences: s.silences,
+		Windows:  s.windowList(),
+		Tags:     s.tags,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return models.WriteFileAtomic(s.path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,89 @@
+package alerts
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWindowStartsAtWallClockOnDSTDays(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	w, err := compileWindow(Window{Name: "nightly", Matcher: Matcher{Hosts: []string{"web-01"}}, Start: "04:00", Duration: "1h", Timezone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clocks went forward on 2024-03-31 and back on 2024-10-27.
+	for _, day := range []int{31 + 29 + 31, 31 + 29 + 31 + 30 + 31 + 30 + 31 + 31 + 30 + 27} {
+		date := time.Date(2024, 1, day, 0, 0, 0, 0, loc)
+		cases := []struct {
+			at     time.Time
+			active bool
+		}{
+			{time.Date(date.Year(), date.Month(), date.Day(), 3, 59, 0, 0, loc), false},
+			{time.Date(date.Year(), date.Month(), date.Day(), 4, 0, 0, 0, loc), true},
+			{time.Date(date.Year(), date.Month(), date.Day(), 4, 59, 0, 0, loc), true},
+			{time.Date(date.Year(), date.Month(), date.Day(), 5, 0, 0, 0, loc), false},
+		}
+		for _, c := range cases {
+			if got := w.active(c.at); got != c.active {
+				t.Errorf("active(%s) = %v, want %v", c.at, got, c.active)
+			}
+		}
+	}
+}
+
+func TestFiringAlertIsSentWhenSilenceEnds(t *testing.T) {
+	dir := t.TempDir()
+	history, err := OpenHistory(filepath.Join(dir, "events.log"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+	silencer, err := NewSilencer(filepath.Join(dir, "silences.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	sl, err := silencer.AddSilence(Silence{Matcher: Matcher{Hosts: []string{"web-01"}}, EndsAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := make(chan Event, 10)
+	d := NewDispatcher(history, silencer, notifierFunc(func(e Event) error {
+		sent <- e
+		return nil
+	}))
+	defer d.Close()
+
+	firing, _ := AlertEvent(Alert{RuleID: "r1", RuleName: "cpu", Host: "web-01", State: StateFiring, FiredAt: now.Unix()})
+	d.Publish(firing)
+	other, _ := AlertEvent(Alert{RuleID: "r2", RuleName: "mem", Host: "web-01", State: StateFiring, FiredAt: now.Unix()})
+	d.Publish(other)
+	resolved, _ := AlertEvent(Alert{RuleID: "r2", RuleName: "mem", Host: "web-01", State: StateResolved, ResolvedAt: now.Unix()})
+	d.Publish(resolved)
+
+	d.Recheck(now.Add(time.Minute))
+	if err := silencer.DeleteSilence(sl.ID); err != nil {
+		t.Fatal(err)
+	}
+	d.Recheck(now.Add(2 * time.Minute))
+	d.Recheck(now.Add(3 * time.Minute))
+
+	select {
+	case e := <-sent:
+		if e.Kind != EventFiring || e.Alert.RuleID != "r1" || e.Silenced {
+			t.Errorf("sent %+v, want the firing event of r1", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("firing event not sent after the silence ended")
+	}
+	select {
+	case e := <-sent:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,68 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/subtle"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthMetadataKey is the gRPC metadata key agents send their token in.
+const AuthMetadataKey = "authorization"
+
+// TokenVerifier resolves a per-agent token to the name it is bound to.
+type TokenVerifier interface {
+	Verify(token string) (string, bool)
+}
+
+type agentNameKey struct{}
+
+// checkToken accepts the shared ingest token or a per-agent token. For a
+// per-agent token the returned context carries the name it is bound to.
+func checkToken(ctx context.Context, token string, agents TokenVerifier) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing metadata")
+	}
+	values := md.Get(AuthMetadataKey)
+	if len(values) == 0 || values[0] == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing auth token")
+	}
+	if token != "" && subtle.ConstantTimeCompare([]byte(values[0]), []byte(token)) == 1 {
+		return ctx, nil
+	}
+	if agents != nil {
+		if name, ok := agents.Verify(values[0]); ok {
+			return context.WithValue(ctx, agentNameKey{}, name), nil
+		}
+	}
+	return nil, status.Error(codes.PermissionDenied, "invalid auth token")
+}
+
+func TokenAuthInterceptor(token string, agents TokenVerifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := checkToken(ctx, token, agents)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func TokenAuthStreamInterceptor(token string, agents TokenVerifier) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if _, err := checkToken(ss.Context(), token, agents); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// AgentNameMatches reports whether name may be pushed with the token of the
+// call. It returns true when the shared ingest token was used.
+func AgentNameMatches(ctx context.Context, name string) bool {
+	agent, ok := ctx.Value(agentNameKey{}).(string)
+	return !ok || agent == name
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"slices"
+)
+
+type peerIdentityKey struct{}
+
+// peerIdentities returns the CN and DNS SANs of the verified client
+// certificate of the peer.
+func peerIdentities(ctx context.Context) ([]string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	cert := info.State.VerifiedChains[0][0]
+	ids := append([]string(nil), cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	return ids, len(ids) > 0
+}
+
+// PeerIdentityInterceptor is used in mTLS mode. It rejects calls without a
+// verified client certificate and stores the certificate identities in the
+// context for the handlers.
+func PeerIdentityInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ids, ok := peerIdentities(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no verified client certificate")
+	}
+	ctx = context.WithValue(ctx, peerIdentityKey{}, ids)
+	return handler(ctx, req)
+}
+
+// PeerIdentityMatches reports whether name matches the identity of the
+// client certificate. It returns true when no identity was recorded, i.e.
+// when the server does not run in mTLS mode.
+func PeerIdentityMatches(ctx context.Context, name string) bool {
+	ids, ok := ctx.Value(peerIdentityKey{}).([]string)
+	if !ok {
+		return true
+	}
+	return slices.Contains(ids, name)
+}
+
+// NameBound reports whether the caller may push name, given both its client
+// certificate and its agent token.
+func NameBound(ctx context.Context, name string) bool {
+	return PeerIdentityMatches(ctx, name) && AgentNameMatches(ctx, name)
+}
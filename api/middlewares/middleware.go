@@ -4,31 +4,93 @@ import (
 	"context"
 	"errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"net"
+	"net/netip"
+	"strings"
 )
 
+// UnixPeer is the client IP recorded for agents connected over a unix socket.
+const UnixPeer = "unix"
+
 type clientIPKey struct{}
 
-func clientIP(ctx context.Context) (string, bool) {
+type reportedTrustedKey struct{}
+
+// clientIP returns the address of the peer and whether the peer is a trusted
+// hop whose forwarding information may be used.
+func clientIP(ctx context.Context, trusted []netip.Prefix) (string, bool, bool) {
 	p, ok := peer.FromContext(ctx)
 	if !ok {
-		return "", false
+		return "", false, false
+	}
+	switch addr := p.Addr.(type) {
+	case *net.TCPAddr:
+		ip, ok := netip.AddrFromSlice(addr.IP)
+		if !ok {
+			return "", false, false
+		}
+		ip = ip.Unmap()
+		return ip.String(), containsAddr(trusted, ip), true
+	case *net.UnixAddr:
+		return UnixPeer, true, true
+	default:
+		return "", false, false
+	}
+}
+
+func containsAddr(cidrs []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range cidrs {
+		if p.Contains(ip) {
+			return true
+		}
 	}
-	addr, ok := p.Addr.(*net.TCPAddr)
+	return false
+}
+
+// forwardedFor walks the x-forwarded-for chain from the right and returns
+// the first address that is not a trusted proxy.
+func forwardedFor(ctx context.Context, trusted []netip.Prefix) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return "", false
 	}
-	return addr.IP.String(), true
+	var hops []string
+	for _, v := range md.Get("x-forwarded-for") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return "", false
+		}
+		ip = ip.Unmap()
+		if i == 0 || !containsAddr(trusted, ip) {
+			return ip.String(), true
+		}
+	}
+	return "", false
 }
 
-func IPExtractorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	ip, ok := clientIP(ctx)
-	if !ok {
-		return nil, errors.New("could not extract client IP from context")
+// IPExtractorInterceptor records the client IP of every call. Connections
+// from trusted proxies or unix sockets may name the client in
+// x-forwarded-for; without it the address reported by the agent is allowed.
+func IPExtractorInterceptor(trusted []netip.Prefix) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ip, fromProxy, ok := clientIP(ctx, trusted)
+		if !ok {
+			return nil, errors.New("could not extract client IP from context")
+		}
+		if fromProxy {
+			if forwarded, ok := forwardedFor(ctx, trusted); ok {
+				ip, fromProxy = forwarded, false
+			}
+		}
+		ctx = context.WithValue(ctx, clientIPKey{}, ip)
+		ctx = context.WithValue(ctx, reportedTrustedKey{}, fromProxy)
+		return handler(ctx, req)
 	}
-	ctx = context.WithValue(ctx, clientIPKey{}, ip)
-	return handler(ctx, req)
 }
 
 func GetClientIP(ctx context.Context) (string, bool) {
@@ -38,3 +100,11 @@ func GetClientIP(ctx context.Context) (string, bool) {
 	}
 	return ip, true
 }
+
+// ReportedAddressTrusted reports whether the agent-reported address may
+// replace the client IP, i.e. the call came from a trusted proxy or a unix
+// socket that did not forward the client address.
+func ReportedAddressTrusted(ctx context.Context) bool {
+	trusted, _ := ctx.Value(reportedTrustedKey{}).(bool)
+	return trusted
+}
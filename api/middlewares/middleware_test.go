@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+var trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+// extract runs IPExtractorInterceptor for a call from addr carrying the
+// given x-forwarded-for values.
+func extract(t *testing.T, addr net.Addr, xff ...string) (string, bool) {
+	t.Helper()
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	if len(xff) > 0 {
+		md := metadata.MD{}
+		for _, v := range xff {
+			md.Append("x-forwarded-for", v)
+		}
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	var ip string
+	var trusted bool
+	_, err := IPExtractorInterceptor(trustedProxies)(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		ip, _ = GetClientIP(ctx)
+		trusted = ReportedAddressTrusted(ctx)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ip, trusted
+}
+
+func tcp(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func TestIPExtractor(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		addr    net.Addr
+		xff     []string
+		ip      string
+		trusted bool
+	}{
+		{"direct", tcp("192.0.2.10"), nil, "192.0.2.10", false},
+		{"direct mapped", tcp("::ffff:192.0.2.10"), nil, "192.0.2.10", false},
+		{"untrusted peer ignores xff", tcp("192.0.2.10"), []string{"198.51.100.7"}, "192.0.2.10", false},
+		{"trusted proxy", tcp("10.0.0.2"), []string{"198.51.100.7"}, "198.51.100.7", false},
+		{"trusted proxy without xff", tcp("10.0.0.2"), nil, "10.0.0.2", true},
+		{"chain of proxies", tcp("10.0.0.2"), []string{"198.51.100.7, 10.0.0.3"}, "198.51.100.7", false},
+		{"spoofed left of client", tcp("10.0.0.2"), []string{"203.0.113.1, 198.51.100.7, 10.0.0.3"}, "198.51.100.7", false},
+		{"several headers", tcp("10.0.0.2"), []string{"203.0.113.1", "198.51.100.7"}, "198.51.100.7", false},
+		{"only proxies", tcp("10.0.0.2"), []string{"10.0.0.4, 10.0.0.3"}, "10.0.0.4", false},
+		{"invalid hop", tcp("10.0.0.2"), []string{"198.51.100.7, bogus"}, "10.0.0.2", true},
+		{"unix socket", &net.UnixAddr{Name: "/run/mon.sock", Net: "unix"}, nil, UnixPeer, true},
+		{"unix socket with xff", &net.UnixAddr{Name: "/run/mon.sock", Net: "unix"}, []string{"198.51.100.7"}, "198.51.100.7", false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ip, trusted := extract(t, c.addr, c.xff...)
+			if ip != c.ip || trusted != c.trusted {
+				t.Errorf("ip, trusted = %s, %v, want %s, %v", ip, trusted, c.ip, c.trusted)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/stupidrun/mon/api/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"sync"
+	"time"
+)
+
+// Counter receives the names of events worth counting, such as rejected
+// calls.
+type Counter interface {
+	Inc(name string)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token bucket per key. Each bucket holds up to burst
+// tokens and refills at perMinute tokens per minute.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewRateLimiter returns nil when perMinute is not positive, which disables
+// limiting; a nil limiter allows every call.
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of key. If none is left it returns
+// false and how long until the next token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that have refilled completely, as they are
+// indistinguishable from new ones. Callers must hold l.mu.
+func (l *RateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.swept) < full {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// IPRateLimitInterceptor limits calls per client IP. It must run after
+// IPExtractorInterceptor and before authentication, so that token guessing
+// is limited as well.
+func IPRateLimitInterceptor(l *RateLimiter, counter Counter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ip, _ := GetClientIP(ctx)
+		if ok, wait := l.Allow(ip); !ok {
+			counter.Inc("grpc_rate_limited_ip_total")
+			return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s, retry in %s", ip, wait.Round(time.Millisecond))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// NameRateLimitInterceptor limits pushes per metric name. It must be the
+// last interceptor: a push naming a host its token or certificate is not
+// bound to is passed on untouched for the handler to reject, so that it
+// does not use up that host's bucket.
+func NameRateLimitInterceptor(l *RateLimiter, counter Counter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		push, ok := req.(*proto.PushMetricsRequest)
+		if !ok {
+			return handler(ctx, req)
+		}
+		for _, m := range push.Metrics {
+			if !NameBound(ctx, m.Name) {
+				return handler(ctx, req)
+			}
+		}
+		seen := make(map[string]bool, 1)
+		for _, m := range push.Metrics {
+			if seen[m.Name] {
+				continue
+			}
+			seen[m.Name] = true
+			if ok, wait := l.Allow(m.Name); !ok {
+				counter.Inc("grpc_rate_limited_name_total")
+				return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %q, retry in %s", m.Name, wait.Round(time.Millisecond))
+			}
+		}
+		return handler(ctx, req)
+	}
+}
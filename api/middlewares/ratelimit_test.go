@@ -0,0 +1,210 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/stupidrun/mon/api/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+// clock is a manual time source for RateLimiter.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(perMinute, burst int) (*RateLimiter, *clock) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	l := NewRateLimiter(perMinute, burst)
+	l.now, l.swept = c.now, c.t
+	return l, c
+}
+
+type counter map[string]int
+
+func (c counter) Inc(name string) { c[name]++ }
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	l, c := newTestLimiter(60, 3)
+	for i := range 3 {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("call %d within the burst was limited", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Fatal("call over the burst was allowed")
+	}
+	if wait != time.Second {
+		t.Errorf("wait = %s, want 1s", wait)
+	}
+
+	c.advance(500 * time.Millisecond)
+	if ok, wait := l.Allow("a"); ok || wait != 500*time.Millisecond {
+		t.Errorf("after 500ms: allowed %v, wait %s, want false, 500ms", ok, wait)
+	}
+	c.advance(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("token not refilled after 1s")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("more than one token refilled after 1s")
+	}
+
+	// Refilling stops at the burst.
+	c.advance(time.Hour)
+	for i := range 3 {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("call %d after an idle hour was limited", i+1)
+		}
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("bucket refilled beyond the burst")
+	}
+}
+
+func TestRateLimiterKeysAreIndependent(t *testing.T) {
+	l, _ := newTestLimiter(60, 1)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first call of a was limited")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("second call of a was allowed")
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("b was limited by the bucket of a")
+	}
+}
+
+func TestRateLimiterSweepsFullBuckets(t *testing.T) {
+	l, c := newTestLimiter(60, 2)
+	l.Allow("a")
+	l.Allow("b")
+	c.advance(2 * time.Second)
+	l.Allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("full bucket of a was not swept")
+	}
+	if _, ok := l.buckets["c"]; !ok {
+		t.Error("bucket of c was swept")
+	}
+}
+
+func TestNilRateLimiterAllows(t *testing.T) {
+	l := NewRateLimiter(0, 10)
+	if l != nil {
+		t.Fatal("NewRateLimiter(0, 10) is not nil")
+	}
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("nil limiter limited a call")
+	}
+}
+
+func pushRequest(names ...string) *proto.PushMetricsRequest {
+	req := &proto.PushMetricsRequest{}
+	for _, name := range names {
+		req.Metrics = append(req.Metrics, &proto.Metric{Name: name})
+	}
+	return req
+}
+
+func okHandler(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+func TestIPRateLimitInterceptor(t *testing.T) {
+	l, _ := newTestLimiter(60, 1)
+	calls := counter{}
+	limit := IPRateLimitInterceptor(l, calls)
+	call := func(ip string) error {
+		_, err := IPExtractorInterceptor(nil)(peerContext(tcp(ip)), pushRequest("web-01"), &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+			return limit(ctx, req, &grpc.UnaryServerInfo{}, okHandler)
+		})
+		return err
+	}
+
+	if err := call("192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := call("192.0.2.1"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second call = %v, want ResourceExhausted", err)
+	}
+	if err := call("192.0.2.2"); err != nil {
+		t.Errorf("other address was limited: %v", err)
+	}
+	if calls["grpc_rate_limited_ip_total"] != 1 {
+		t.Errorf("counted %v, want one limited call", calls)
+	}
+}
+
+func TestNameRateLimitInterceptor(t *testing.T) {
+	l, _ := newTestLimiter(60, 1)
+	calls := counter{}
+	limit := NameRateLimitInterceptor(l, calls)
+	ctx := context.Background()
+
+	// A batch takes one token per name, however many metrics it holds.
+	if _, err := limit(ctx, pushRequest("web-01", "web-01"), &grpc.UnaryServerInfo{}, okHandler); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limit(ctx, pushRequest("web-02", "web-01"), &grpc.UnaryServerInfo{}, okHandler); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("push over the limit of web-01 = %v, want ResourceExhausted", err)
+	}
+	if calls["grpc_rate_limited_name_total"] != 1 {
+		t.Errorf("counted %v, want one limited call", calls)
+	}
+	// Other calls are not limited by name.
+	for range 3 {
+		if _, err := limit(ctx, &proto.PushMetricsResponse{}, &grpc.UnaryServerInfo{}, okHandler); err != nil {
+			t.Errorf("non-push call was limited: %v", err)
+		}
+	}
+}
+
+type agentTokens map[string]string
+
+func (a agentTokens) Verify(token string) (string, bool) {
+	name, ok := a[token]
+	return name, ok
+}
+
+// A push for a name the caller is not bound to is rejected by the handler
+// and must not spend that name's bucket.
+func TestUnboundPushDoesNotSpendNameBucket(t *testing.T) {
+	l, _ := newTestLimiter(60, 1)
+	tokens := agentTokens{"token-a": "web-01", "token-b": "web-02"}
+	auth := TokenAuthInterceptor("", tokens)
+	limit := NameRateLimitInterceptor(l, counter{})
+	handler := func(ctx context.Context, req any) (any, error) {
+		for _, m := range req.(*proto.PushMetricsRequest).Metrics {
+			if !NameBound(ctx, m.Name) {
+				return nil, status.Error(codes.PermissionDenied, "not bound")
+			}
+		}
+		return "ok", nil
+	}
+	push := func(token, name string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthMetadataKey, token))
+		_, err := auth(ctx, pushRequest(name), &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+			return limit(ctx, req, &grpc.UnaryServerInfo{}, handler)
+		})
+		return err
+	}
+
+	for range 5 {
+		if err := push("token-a", "web-02"); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("push for web-02 with the token of web-01 = %v, want PermissionDenied", err)
+		}
+	}
+	if err := push("token-b", "web-02"); err != nil {
+		t.Errorf("push of web-02 after foreign pushes = %v, want success", err)
+	}
+}
+
+func peerContext(addr net.Addr) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+}
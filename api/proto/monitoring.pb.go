@@ -21,21 +21,388 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-type Metric struct {
+type SampleType int32
+
+const (
+	SampleType_GAUGE     SampleType = 0
+	SampleType_COUNTER   SampleType = 1
+	SampleType_HISTOGRAM SampleType = 2
+	SampleType_SUMMARY   SampleType = 3
+)
+
+// Enum value maps for SampleType.
+var (
+	SampleType_name = map[int32]string{
+		0: "GAUGE",
+		1: "COUNTER",
+		2: "HISTOGRAM",
+		3: "SUMMARY",
+	}
+	SampleType_value = map[string]int32{
+		"GAUGE":     0,
+		"COUNTER":   1,
+		"HISTOGRAM": 2,
+		"SUMMARY":   3,
+	}
+)
+
+func (x SampleType) Enum() *SampleType {
+	p := new(SampleType)
+	*p = x
+	return p
+}
+
+func (x SampleType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SampleType) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_proto_monitoring_proto_enumTypes[0].Descriptor()
+}
+
+func (SampleType) Type() protoreflect.EnumType {
+	return &file_api_proto_monitoring_proto_enumTypes[0]
+}
+
+func (x SampleType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SampleType.Descriptor instead.
+func (SampleType) EnumDescriptor() ([]byte, []int) {
+	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{0}
+}
+
+// Bucket counts are cumulative. The implicit +Inf bucket is the histogram
+// count and must not be sent as a bucket.
+type Bucket struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UpperBound    float64                `protobuf:"fixed64,1,opt,name=upper_bound,json=upperBound,proto3" json:"upper_bound,omitempty"`
+	Count         uint64                 `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Bucket) Reset() {
+	*x = Bucket{}
+	mi := &file_api_proto_monitoring_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Bucket) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Bucket) ProtoMessage() {}
+
+func (x *Bucket) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_monitoring_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Bucket.ProtoReflect.Descriptor instead.
+func (*Bucket) Descriptor() ([]byte, []int) {
+	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Bucket) GetUpperBound() float64 {
+	if x != nil {
+		return x.UpperBound
+	}
+	return 0
+}
+
+func (x *Bucket) GetCount() uint64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type Histogram struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Buckets       []*Bucket              `protobuf:"bytes,1,rep,name=buckets,proto3" json:"buckets,omitempty"`
+	Sum           float64                `protobuf:"fixed64,2,opt,name=sum,proto3" json:"sum,omitempty"`
+	Count         uint64                 `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Histogram) Reset() {
+	*x = Histogram{}
+	mi := &file_api_proto_monitoring_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Histogram) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Histogram) ProtoMessage() {}
+
+func (x *Histogram) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_monitoring_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Histogram.ProtoReflect.Descriptor instead.
+func (*Histogram) Descriptor() ([]byte, []int) {
+	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Histogram) GetBuckets() []*Bucket {
+	if x != nil {
+		return x.Buckets
+	}
+	return nil
+}
+
+func (x *Histogram) GetSum() float64 {
+	if x != nil {
+		return x.Sum
+	}
+	return 0
+}
+
+func (x *Histogram) GetCount() uint64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type Quantile struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Quantile      float64                `protobuf:"fixed64,1,opt,name=quantile,proto3" json:"quantile,omitempty"`
+	Value         float64                `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Quantile) Reset() {
+	*x = Quantile{}
+	mi := &file_api_proto_monitoring_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Quantile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Quantile) ProtoMessage() {}
+
+func (x *Quantile) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_monitoring_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Quantile.ProtoReflect.Descriptor instead.
+func (*Quantile) Descriptor() ([]byte, []int) {
+	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Quantile) GetQuantile() float64 {
+	if x != nil {
+		return x.Quantile
+	}
+	return 0
+}
+
+func (x *Quantile) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+type Summary struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Quantiles     []*Quantile            `protobuf:"bytes,1,rep,name=quantiles,proto3" json:"quantiles,omitempty"`
+	Sum           float64                `protobuf:"fixed64,2,opt,name=sum,proto3" json:"sum,omitempty"`
+	Count         uint64                 `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Summary) Reset() {
+	*x = Summary{}
+	mi := &file_api_proto_monitoring_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Summary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Summary) ProtoMessage() {}
+
+func (x *Summary) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_monitoring_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Summary.ProtoReflect.Descriptor instead.
+func (*Summary) Descriptor() ([]byte, []int) {
+	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Summary) GetQuantiles() []*Quantile {
+	if x != nil {
+		return x.Quantiles
+	}
+	return nil
+}
+
+func (x *Summary) GetSum() float64 {
+	if x != nil {
+		return x.Sum
+	}
+	return 0
+}
+
+func (x *Summary) GetCount() uint64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type Sample struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
-	CpuUsage      float64                `protobuf:"fixed64,2,opt,name=cpu_usage,json=cpuUsage,proto3" json:"cpu_usage,omitempty"`
-	MemoryUsage   float64                `protobuf:"fixed64,3,opt,name=memory_usage,json=memoryUsage,proto3" json:"memory_usage,omitempty"`
-	NetworkIn     float64                `protobuf:"fixed64,4,opt,name=network_in,json=networkIn,proto3" json:"network_in,omitempty"`
-	NetworkOut    float64                `protobuf:"fixed64,5,opt,name=network_out,json=networkOut,proto3" json:"network_out,omitempty"`
-	Timestamp     int64                  `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Value         float64                `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	Type          SampleType             `protobuf:"varint,3,opt,name=type,proto3,enum=monitoring.SampleType" json:"type,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Histogram     *Histogram             `protobuf:"bytes,5,opt,name=histogram,proto3" json:"histogram,omitempty"`
+	Summary       *Summary               `protobuf:"bytes,6,opt,name=summary,proto3" json:"summary,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Sample) Reset() {
+	*x = Sample{}
+	mi := &file_api_proto_monitoring_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Sample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sample) ProtoMessage() {}
+
+func (x *Sample) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_monitoring_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sample.ProtoReflect.Descriptor instead.
+func (*Sample) Descriptor() ([]byte, []int) {
+	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Sample) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Sample) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *Sample) GetType() SampleType {
+	if x != nil {
+		return x.Type
+	}
+	return SampleType_GAUGE
+}
+
+func (x *Sample) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Sample) GetHistogram() *Histogram {
+	if x != nil {
+		return x.Histogram
+	}
+	return nil
+}
+
+func (x *Sample) GetSummary() *Summary {
+	if x != nil {
+		return x.Summary
+	}
+	return nil
+}
+
+type Metric struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Name        string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	CpuUsage    float64                `protobuf:"fixed64,2,opt,name=cpu_usage,json=cpuUsage,proto3" json:"cpu_usage,omitempty"`
+	MemoryUsage float64                `protobuf:"fixed64,3,opt,name=memory_usage,json=memoryUsage,proto3" json:"memory_usage,omitempty"`
+	NetworkIn   float64                `protobuf:"fixed64,4,opt,name=network_in,json=networkIn,proto3" json:"network_in,omitempty"`
+	NetworkOut  float64                `protobuf:"fixed64,5,opt,name=network_out,json=networkOut,proto3" json:"network_out,omitempty"`
+	Timestamp   int64                  `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Samples     []*Sample              `protobuf:"bytes,7,rep,name=samples,proto3" json:"samples,omitempty"`
+	// Address the agent reports for itself. The server only uses it when the
+	// connection comes from a trusted proxy or a unix socket and no better
+	// source address is known.
+	Address       string `protobuf:"bytes,8,opt,name=address,proto3" json:"address,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Metric) Reset() {
 	*x = Metric{}
-	mi := &file_api_proto_monitoring_proto_msgTypes[0]
+	mi := &file_api_proto_monitoring_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -47,7 +414,7 @@ func (x *Metric) String() string {
 func (*Metric) ProtoMessage() {}
 
 func (x *Metric) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_monitoring_proto_msgTypes[0]
+	mi := &file_api_proto_monitoring_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -60,7 +427,7 @@ func (x *Metric) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Metric.ProtoReflect.Descriptor instead.
 func (*Metric) Descriptor() ([]byte, []int) {
-	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{0}
+	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *Metric) GetName() string {
@@ -105,6 +472,20 @@ func (x *Metric) GetTimestamp() int64 {
 	return 0
 }
 
+func (x *Metric) GetSamples() []*Sample {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
+func (x *Metric) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
 type PushMetricsRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
@@ -114,7 +495,7 @@ type PushMetricsRequest struct {
 
 func (x *PushMetricsRequest) Reset() {
 	*x = PushMetricsRequest{}
-	mi := &file_api_proto_monitoring_proto_msgTypes[1]
+	mi := &file_api_proto_monitoring_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -126,7 +507,7 @@ func (x *PushMetricsRequest) String() string {
 func (*PushMetricsRequest) ProtoMessage() {}
 
 func (x *PushMetricsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_monitoring_proto_msgTypes[1]
+	mi := &file_api_proto_monitoring_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -139,7 +520,7 @@ func (x *PushMetricsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PushMetricsRequest.ProtoReflect.Descriptor instead.
 func (*PushMetricsRequest) Descriptor() ([]byte, []int) {
-	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{1}
+	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *PushMetricsRequest) GetMetrics() []*Metric {
@@ -159,7 +540,7 @@ type PushMetricsResponse struct {
 
 func (x *PushMetricsResponse) Reset() {
 	*x = PushMetricsResponse{}
-	mi := &file_api_proto_monitoring_proto_msgTypes[2]
+	mi := &file_api_proto_monitoring_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -171,7 +552,7 @@ func (x *PushMetricsResponse) String() string {
 func (*PushMetricsResponse) ProtoMessage() {}
 
 func (x *PushMetricsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_monitoring_proto_msgTypes[2]
+	mi := &file_api_proto_monitoring_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -184,7 +565,7 @@ func (x *PushMetricsResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PushMetricsResponse.ProtoReflect.Descriptor instead.
 func (*PushMetricsResponse) Descriptor() ([]byte, []int) {
-	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{2}
+	return file_api_proto_monitoring_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *PushMetricsResponse) GetSuccess() bool {
@@ -206,7 +587,32 @@ var File_api_proto_monitoring_proto protoreflect.FileDescriptor
 const file_api_proto_monitoring_proto_rawDesc = "" +
 	"\n" +
 	"\x1aapi/proto/monitoring.proto\x12\n" +
-	"monitoring\"\xba\x01\n" +
+	"monitoring\"?\n" +
+	"\x06Bucket\x12\x1f\n" +
+	"\vupper_bound\x18\x01 \x01(\x01R\n" +
+	"upperBound\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x04R\x05count\"a\n" +
+	"\tHistogram\x12,\n" +
+	"\abuckets\x18\x01 \x03(\v2\x12.monitoring.BucketR\abuckets\x12\x10\n" +
+	"\x03sum\x18\x02 \x01(\x01R\x03sum\x12\x14\n" +
+	"\x05count\x18\x03 \x01(\x04R\x05count\"<\n" +
+	"\bQuantile\x12\x1a\n" +
+	"\bquantile\x18\x01 \x01(\x01R\bquantile\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value\"e\n" +
+	"\aSummary\x122\n" +
+	"\tquantiles\x18\x01 \x03(\v2\x14.monitoring.QuantileR\tquantiles\x12\x10\n" +
+	"\x03sum\x18\x02 \x01(\x01R\x03sum\x12\x14\n" +
+	"\x05count\x18\x03 \x01(\x04R\x05count\"\xb5\x02\n" +
+	"\x06Sample\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value\x12*\n" +
+	"\x04type\x18\x03 \x01(\x0e2\x16.monitoring.SampleTypeR\x04type\x126\n" +
+	"\x06labels\x18\x04 \x03(\v2\x1e.monitoring.Sample.LabelsEntryR\x06labels\x123\n" +
+	"\thistogram\x18\x05 \x01(\v2\x15.monitoring.HistogramR\thistogram\x12-\n" +
+	"\asummary\x18\x06 \x01(\v2\x13.monitoring.SummaryR\asummary\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x82\x02\n" +
 	"\x06Metric\x12\x12\n" +
 	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1b\n" +
 	"\tcpu_usage\x18\x02 \x01(\x01R\bcpuUsage\x12!\n" +
@@ -215,12 +621,20 @@ const file_api_proto_monitoring_proto_rawDesc = "" +
 	"network_in\x18\x04 \x01(\x01R\tnetworkIn\x12\x1f\n" +
 	"\vnetwork_out\x18\x05 \x01(\x01R\n" +
 	"networkOut\x12\x1c\n" +
-	"\ttimestamp\x18\x06 \x01(\x03R\ttimestamp\"B\n" +
+	"\ttimestamp\x18\x06 \x01(\x03R\ttimestamp\x12,\n" +
+	"\asamples\x18\a \x03(\v2\x12.monitoring.SampleR\asamples\x12\x18\n" +
+	"\aaddress\x18\b \x01(\tR\aaddress\"B\n" +
 	"\x12PushMetricsRequest\x12,\n" +
 	"\ametrics\x18\x01 \x03(\v2\x12.monitoring.MetricR\ametrics\"I\n" +
 	"\x13PushMetricsResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage2c\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage*@\n" +
+	"\n" +
+	"SampleType\x12\t\n" +
+	"\x05GAUGE\x10\x00\x12\v\n" +
+	"\aCOUNTER\x10\x01\x12\r\n" +
+	"\tHISTOGRAM\x10\x02\x12\v\n" +
+	"\aSUMMARY\x10\x032c\n" +
 	"\x11MonitoringService\x12N\n" +
 	"\vPushMetrics\x12\x1e.monitoring.PushMetricsRequest\x1a\x1f.monitoring.PushMetricsResponseB$Z\"github.com/stupidrun/mon/api/protob\x06proto3"
 
@@ -236,21 +650,36 @@ func file_api_proto_monitoring_proto_rawDescGZIP() []byte {
 	return file_api_proto_monitoring_proto_rawDescData
 }
 
-var file_api_proto_monitoring_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_api_proto_monitoring_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_api_proto_monitoring_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_api_proto_monitoring_proto_goTypes = []any{
-	(*Metric)(nil),              // 0: monitoring.Metric
-	(*PushMetricsRequest)(nil),  // 1: monitoring.PushMetricsRequest
-	(*PushMetricsResponse)(nil), // 2: monitoring.PushMetricsResponse
+	(SampleType)(0),             // 0: monitoring.SampleType
+	(*Bucket)(nil),              // 1: monitoring.Bucket
+	(*Histogram)(nil),           // 2: monitoring.Histogram
+	(*Quantile)(nil),            // 3: monitoring.Quantile
+	(*Summary)(nil),             // 4: monitoring.Summary
+	(*Sample)(nil),              // 5: monitoring.Sample
+	(*Metric)(nil),              // 6: monitoring.Metric
+	(*PushMetricsRequest)(nil),  // 7: monitoring.PushMetricsRequest
+	(*PushMetricsResponse)(nil), // 8: monitoring.PushMetricsResponse
+	nil,                         // 9: monitoring.Sample.LabelsEntry
 }
 var file_api_proto_monitoring_proto_depIdxs = []int32{
-	0, // 0: monitoring.PushMetricsRequest.metrics:type_name -> monitoring.Metric
-	1, // 1: monitoring.MonitoringService.PushMetrics:input_type -> monitoring.PushMetricsRequest
-	2, // 2: monitoring.MonitoringService.PushMetrics:output_type -> monitoring.PushMetricsResponse
-	2, // [2:3] is the sub-list for method output_type
-	1, // [1:2] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1, // 0: monitoring.Histogram.buckets:type_name -> monitoring.Bucket
+	3, // 1: monitoring.Summary.quantiles:type_name -> monitoring.Quantile
+	0, // 2: monitoring.Sample.type:type_name -> monitoring.SampleType
+	9, // 3: monitoring.Sample.labels:type_name -> monitoring.Sample.LabelsEntry
+	2, // 4: monitoring.Sample.histogram:type_name -> monitoring.Histogram
+	4, // 5: monitoring.Sample.summary:type_name -> monitoring.Summary
+	5, // 6: monitoring.Metric.samples:type_name -> monitoring.Sample
+	6, // 7: monitoring.PushMetricsRequest.metrics:type_name -> monitoring.Metric
+	7, // 8: monitoring.MonitoringService.PushMetrics:input_type -> monitoring.PushMetricsRequest
+	8, // 9: monitoring.MonitoringService.PushMetrics:output_type -> monitoring.PushMetricsResponse
+	9, // [9:10] is the sub-list for method output_type
+	8, // [8:9] is the sub-list for method input_type
+	8, // [8:8] is the sub-list for extension type_name
+	8, // [8:8] is the sub-list for extension extendee
+	0, // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_api_proto_monitoring_proto_init() }
@@ -263,13 +692,14 @@ func file_api_proto_monitoring_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_proto_monitoring_proto_rawDesc), len(file_api_proto_monitoring_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   3,
+			NumEnums:      1,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_api_proto_monitoring_proto_goTypes,
 		DependencyIndexes: file_api_proto_monitoring_proto_depIdxs,
+		EnumInfos:         file_api_proto_monitoring_proto_enumTypes,
 		MessageInfos:      file_api_proto_monitoring_proto_msgTypes,
 	}.Build()
 	File_api_proto_monitoring_proto = out.File
@@ -0,0 +1,84 @@
+package bootstrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stupidrun/mon/models"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxAuditPayload caps the request body kept in an audit entry.
+const maxAuditPayload = 64 * 1024
+
+// auditMiddleware records every request that may change state, including
+// the ones rejected by authentication. It must run before authMiddleware
+// so that it sees those rejections, and reads the caller identity that
+// authMiddleware leaves in the context once the request is done. The
+// per-IP rate limit must run before it, since every entry is synced to
+// disk.
+func auditMiddleware(audit *models.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		var payload json.RawMessage
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAuditPayload+1))
+			if err == nil {
+				c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+				payload = auditPayload(body)
+			}
+		}
+
+		c.Next()
+
+		key := c.GetString(ctxKeyID)
+		role, _ := c.Get(ctxRole)
+		if key == "" && role != nil {
+			key = "auth-token"
+		}
+		r, _ := role.(models.Role)
+		entry := models.AuditEntry{
+			Time:   time.Now().UTC().Unix(),
+			IP:     c.ClientIP(),
+			Method: c.Request.Method,
+			Path:   c.Request.URL.Path,
+			Status: c.Writer.Status(),
+		}
+		// Rejected callers get a minimal entry, so that anonymous requests
+		// cannot grow the log by the size of their bodies.
+		switch entry.Status {
+		case http.StatusUnauthorized, http.StatusForbidden:
+		default:
+			entry.Key = key
+			entry.Role = r
+			entry.Route = c.FullPath()
+			entry.Payload = payload
+		}
+		err := audit.Append(entry)
+		if err != nil {
+			log.Printf("Failed to write audit entry for %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+		}
+	}
+}
+
+// auditPayload keeps a JSON body as is and anything else as a JSON string.
+func auditPayload(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if len(body) > maxAuditPayload {
+		body = append(body[:maxAuditPayload:maxAuditPayload], "...(truncated)"...)
+	} else if json.Valid(body) {
+		return body
+	}
+	s, _ := json.Marshal(string(body))
+	return s
+}
@@ -0,0 +1,102 @@
+package bootstrap
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"github.com/stupidrun/mon/api/middlewares"
+	"github.com/stupidrun/mon/models"
+	"math"
+	"strconv"
+)
+
+const (
+	ctxRole  = "role"
+	ctxKeyID = "key_id"
+)
+
+// authMiddleware authenticates the caller with AUTH_TOKEN, which has the
+// admin role, or with an API key, and records the role for requireRole.
+func authMiddleware(token string, keys *models.TokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.AbortWithStatusJSON(401, gin.H{
+				"success": false,
+				"message": "unauthorized",
+			})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(authHeader), []byte(token)) == 1 {
+			c.Set(ctxRole, models.RoleAdmin)
+			c.Next()
+			return
+		}
+		key, ok := keys.Lookup(authHeader)
+		if !ok {
+			c.AbortWithStatusJSON(403, gin.H{
+				"success": false,
+				"message": "forbidden",
+			})
+			return
+		}
+		c.Set(ctxRole, key.Role)
+		c.Set(ctxKeyID, key.ID)
+		c.Next()
+	}
+}
+
+// requireRole rejects callers whose role does not include role.
+func requireRole(role models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		have, _ := c.Get(ctxRole)
+		if r, ok := have.(models.Role); !ok || !r.Allows(role) {
+			c.AbortWithStatusJSON(403, gin.H{
+				"success": false,
+				"message": "requires " + string(role) + " role",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ipRateLimitMiddleware limits requests per client IP. It runs ahead of
+// authentication so that key guessing is throttled as well.
+func ipRateLimitMiddleware(l *middlewares.RateLimiter, counter middlewares.Counter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !allow(c, l, c.ClientIP()) {
+			counter.Inc("http_rate_limited_ip_total")
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitMiddleware limits requests per API key. Callers using AUTH_TOKEN
+// share one bucket.
+func rateLimitMiddleware(l *middlewares.RateLimiter, counter middlewares.Counter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetString(ctxKeyID)
+		if key == "" {
+			key = "auth-token"
+		}
+		if !allow(c, l, key) {
+			counter.Inc("http_rate_limited_total")
+			return
+		}
+		c.Next()
+	}
+}
+
+// allow takes a token for key, or aborts the request with 429.
+func allow(c *gin.Context, l *middlewares.RateLimiter, key string) bool {
+	ok, wait := l.Allow(key)
+	if !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(429, gin.H{
+			"success": false,
+			"message": "rate limit exceeded",
+		})
+	}
+	return ok
+}
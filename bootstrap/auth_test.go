@@ -0,0 +1,127 @@
+package bootstrap
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/stupidrun/mon/api/middlewares"
+	"github.com/stupidrun/mon/api/proto"
+	"github.com/stupidrun/mon/config"
+	"github.com/stupidrun/mon/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+type counter map[string]int
+
+func (c counter) Inc(name string) { c[name]++ }
+
+// chain composes interceptors the way grpc.ChainUnaryInterceptor does.
+func chain(interceptors []grpc.UnaryServerInterceptor, handler grpc.UnaryHandler) grpc.UnaryHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, next := interceptors[i], handler
+		handler = func(ctx context.Context, req any) (any, error) {
+			return ic(ctx, req, &grpc.UnaryServerInfo{}, next)
+		}
+	}
+	return handler
+}
+
+// An agent must not be able to drain the name bucket of another host by
+// pushing under its name; the name limiter has to run after the bindings
+// are known.
+func TestServerChainLimitsNamesAfterAuth(t *testing.T) {
+	tokens, err := models.OpenTokenStore(filepath.Join(t.TempDir(), "agents.json"), "mon_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tokenA, err := tokens.Issue("web-01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tokenB, err := tokens.Issue("web-02", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &config.Config{NameRatePerMin: 60, NameRateBurst: 1}
+	push := chain(unaryInterceptors(c, nil, tokens, counter{}, false), func(ctx context.Context, req any) (any, error) {
+		// PushMetrics rejects names the caller is not bound to.
+		for _, m := range req.(*proto.PushMetricsRequest).Metrics {
+			if !middlewares.NameBound(ctx, m.Name) {
+				return nil, status.Error(codes.PermissionDenied, "not bound")
+			}
+		}
+		return &proto.PushMetricsResponse{Success: true}, nil
+	})
+	call := func(token, name string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(middlewares.AuthMetadataKey, token))
+		_, err := push(ctx, &proto.PushMetricsRequest{Metrics: []*proto.Metric{{Name: name}}})
+		return err
+	}
+
+	for range 5 {
+		if err := call(tokenA, "web-02"); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("push for web-02 with the token of web-01 = %v, want PermissionDenied", err)
+		}
+	}
+	if err := call(tokenB, "web-02"); err != nil {
+		t.Errorf("push of web-02 after foreign pushes = %v, want success", err)
+	}
+	if err := call(tokenB, "web-02"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second push of web-02 = %v, want ResourceExhausted", err)
+	}
+	if err := call("bogus", "web-02"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("push with an invalid token = %v, want PermissionDenied", err)
+	}
+}
+
+func TestHTTPRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := counter{}
+	r := gin.New()
+	r.Use(ipRateLimitMiddleware(middlewares.NewRateLimiter(60, 2), calls))
+	r.Use(func(c *gin.Context) {
+		if key := c.GetHeader("X-Key"); key != "" {
+			c.Set(ctxKeyID, key)
+		}
+	})
+	r.Use(rateLimitMiddleware(middlewares.NewRateLimiter(60, 1), calls))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(ip, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":40000"
+		req.Header.Set("X-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("192.0.2.1", "a"); w.Code != http.StatusOK {
+		t.Fatalf("first request = %d", w.Code)
+	}
+	w := get("192.0.2.1", "a")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request of key a = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if w := get("192.0.2.1", "b"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("third request from the address = %d, want 429", w.Code)
+	}
+	if w := get("192.0.2.2", "b"); w.Code != http.StatusOK {
+		t.Errorf("request of key b from another address = %d, want 200", w.Code)
+	}
+	if calls["http_rate_limited_total"] != 1 || calls["http_rate_limited_ip_total"] != 1 {
+		t.Errorf("counted %v, want one limited call of each kind", calls)
+	}
+}
@@ -2,24 +2,58 @@ package bootstrap
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/stupidrun/mon/api/middlewares"
 	"github.com/stupidrun/mon/api/proto"
 	"github.com/stupidrun/mon/utils"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
 	"log"
+	"net/http"
+	"sync"
 	"time"
 )
 
 type Client struct {
 	conn          *grpc.ClientConn
 	name          string
+	address       string
 	monitorClient proto.MonitoringServiceClient
+
+	mu      sync.Mutex
+	pending []*proto.Sample
+	dropped uint64
+}
+
+// maxPendingSamples 限制等待推送的本地指标数量, 服务端长时间不可达时丢弃最旧的
+const maxPendingSamples = 10000
+
+// tokenCredentials 在每次调用的 metadata 中携带认证 token
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{middlewares.AuthMetadataKey: string(t)}, nil
 }
 
-func NewClient(addr string, clientName string) (*Client, error) {
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// NewClient 创建 agent 客户端, tlsConfig 为 nil 时使用明文连接
+func NewClient(addr string, clientName string, token string, tlsConfig *tls.Config) (*Client, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
 	clientConn, err := grpc.Dial(
 		addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(tokenCredentials(token)),
 		grpc.WithTimeout(5*time.Second),
 	)
 	if err != nil {
@@ -33,6 +67,11 @@ func NewClient(addr string, clientName string) (*Client, error) {
 	}, nil
 }
 
+// SetAddress 设置随指标上报的本机地址, 服务端仅在经由可信代理或 unix socket 连接时采用
+func (c *Client) SetAddress(address string) {
+	c.address = address
+}
+
 func (c *Client) Close() error {
 	if c.conn != nil {
 		return c.conn.Close()
@@ -47,6 +86,83 @@ func (c *Client) PushMetrics(ctx context.Context, metrics []*proto.Metric) (*pro
 	return c.monitorClient.PushMetrics(ctx, req)
 }
 
+// AddSamples 缓存由本机其他服务提交的指标, 随下一次推送一起发送
+func (c *Client) AddSamples(samples ...*proto.Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, samples...)
+	c.trimSamples()
+}
+
+// requeueSamples 将推送失败的指标放回队列, 它们比之后提交的指标更早
+func (c *Client) requeueSamples(samples []*proto.Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(samples, c.pending...)
+	c.trimSamples()
+}
+
+// trimSamples 丢弃超出上限的最旧指标, 调用时须持有 c.mu
+func (c *Client) trimSamples() {
+	n := len(c.pending) - maxPendingSamples
+	if n <= 0 {
+		return
+	}
+	c.pending = append([]*proto.Sample(nil), c.pending[n:]...)
+	c.dropped += uint64(n)
+	log.Printf("待推送的本地指标超过 %d 条, 丢弃最旧的 %d 条, 累计丢弃 %d 条", maxPendingSamples, n, c.dropped)
+}
+
+func (c *Client) takeSamples() []*proto.Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	samples := c.pending
+	c.pending = nil
+	return samples
+}
+
+// ServeLocalSamples 在 addr 上接收本机服务以 JSON 提交的指标(如请求延迟直方图),
+// 请求体为 proto.Metric 的 JSON 格式, 只使用其中的 samples 字段
+func ServeLocalSamples(ctx context.Context, addr string, client *Client) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/samples", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var metric proto.Metric
+		if err := protojson.Unmarshal(body, &metric); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, s := range metric.Samples {
+			if !utils.FiniteSample(s) {
+				http.Error(w, fmt.Sprintf("指标 %q 含有 NaN 或 Inf", s.Name), http.StatusBadRequest)
+				return
+			}
+		}
+		client.AddSamples(metric.Samples...)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	log.Printf("本地指标接收服务监听于 %s", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
 func StartPeriodicMetricsCollection(ctx context.Context, client *Client, interval int) {
 	ticker := time.NewTicker(time.Second * time.Duration(interval))
 	defer ticker.Stop()
@@ -66,9 +182,14 @@ func StartPeriodicMetricsCollection(ctx context.Context, client *Client, interva
 			}
 
 			metric.Name = client.name
+			metric.Address = client.address
+			local := client.takeSamples()
+			metric.Samples = append(metric.Samples, local...)
 			_, err = client.PushMetrics(ctx, []*proto.Metric{metric})
 			if err != nil {
 				log.Printf("推送指标失败: %v", err)
+				// 保留本地服务提交的指标, 下次推送时重试
+				client.requeueSamples(local)
 				continue
 			}
 
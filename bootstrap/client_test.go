@@ -0,0 +1,34 @@
+package bootstrap
+
+import (
+	"fmt"
+	"github.com/stupidrun/mon/api/proto"
+	"testing"
+)
+
+func TestPendingSamplesAreCapped(t *testing.T) {
+	c := &Client{}
+	sample := func(i int) *proto.Sample { return &proto.Sample{Name: fmt.Sprint(i)} }
+
+	var old []*proto.Sample
+	for i := range maxPendingSamples {
+		old = append(old, sample(i))
+	}
+	c.AddSamples(old...)
+	taken := c.takeSamples()
+	c.AddSamples(sample(maxPendingSamples), sample(maxPendingSamples+1))
+	// A failed push puts its samples back ahead of the newer ones, and the
+	// oldest of them are dropped.
+	c.requeueSamples(taken)
+
+	pending := c.takeSamples()
+	if len(pending) != maxPendingSamples {
+		t.Fatalf("%d pending samples, want %d", len(pending), maxPendingSamples)
+	}
+	if c.dropped != 2 {
+		t.Errorf("dropped = %d, want 2", c.dropped)
+	}
+	if first, last := pending[0].Name, pending[len(pending)-1].Name; first != "2" || last != fmt.Sprint(maxPendingSamples+1) {
+		t.Errorf("pending samples run from %s to %s, want 2 to %d", first, last, maxPendingSamples+1)
+	}
+}
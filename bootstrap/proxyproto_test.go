@@ -0,0 +1,162 @@
+package bootstrap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2 builds a v2 header with the given command, family and address
+// block.
+func proxyV2(cmd, family byte, body []byte) string {
+	hdr := append([]byte(nil), proxyV2Signature...)
+	hdr = append(hdr, 0x20|cmd, family<<4|1)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(body)))
+	return string(append(hdr, body...))
+}
+
+func v2Inet4(src string, port uint16) []byte {
+	body := netip.MustParseAddr(src).AsSlice()
+	body = append(body, 10, 0, 0, 1)
+	body = binary.BigEndian.AppendUint16(body, port)
+	return binary.BigEndian.AppendUint16(body, 7000)
+}
+
+func v2Inet6(src string, port uint16) []byte {
+	body := netip.MustParseAddr(src).AsSlice()
+	body = append(body, netip.MustParseAddr("2001:db8::1").AsSlice()...)
+	body = binary.BigEndian.AppendUint16(body, port)
+	return binary.BigEndian.AppendUint16(body, 7000)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		header string
+		// remote is the expected source, empty when the proxy's own address
+		// is to be used.
+		remote string
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.10 10.0.0.1 51000 7000\r\n", "192.0.2.10:51000"},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::10 2001:db8::1 51000 7000\r\n", "[2001:db8::10]:51000"},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", ""},
+		{"v1 unknown with addresses", "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", ""},
+		{"v2 inet", proxyV2(1, 1, v2Inet4("192.0.2.10", 51000)), "192.0.2.10:51000"},
+		{"v2 inet6", proxyV2(1, 2, v2Inet6("2001:db8::10", 51000)), "[2001:db8::10]:51000"},
+		{"v2 inet6 mapped", proxyV2(1, 2, v2Inet6("::ffff:192.0.2.10", 51000)), "192.0.2.10:51000"},
+		{"v2 inet with tlvs", proxyV2(1, 1, append(v2Inet4("192.0.2.10", 51000), 0x04, 0, 1, 0)), "192.0.2.10:51000"},
+		{"v2 local", proxyV2(0, 1, v2Inet4("192.0.2.10", 51000)), ""},
+		{"v2 unix", proxyV2(1, 3, make([]byte, 216)), ""},
+		{"v2 unspec", proxyV2(1, 0, nil), ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(c.header + "payload"))
+			addr, err := readProxyHeader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != c.remote {
+				t.Errorf("remote = %q, want %q", got, c.remote)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+				t.Errorf("data after header = %q, want %q", rest, "payload")
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderErrors(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		header string
+	}{
+		{"no header", "GET / HTTP/1.1\r\n\r\n"},
+		{"empty", ""},
+		{"v1 truncated", "PROXY TCP4 192.0.2.10 10.0.0.1 51000"},
+		{"v1 oversized", "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"},
+		{"v1 missing port", "PROXY TCP4 192.0.2.10 10.0.0.1 51000\r\n"},
+		{"v1 bad address", "PROXY TCP4 example.com 10.0.0.1 51000 7000\r\n"},
+		{"v1 bad port", "PROXY TCP4 192.0.2.10 10.0.0.1 70000 7000\r\n"},
+		{"v1 bad protocol", "PROXY UDP4 192.0.2.10 10.0.0.1 51000 7000\r\n"},
+		{"v2 truncated header", proxyV2(1, 1, v2Inet4("192.0.2.10", 51000))[:14]},
+		{"v2 truncated body", proxyV2(1, 1, v2Inet4("192.0.2.10", 51000))[:20]},
+		{"v2 short inet", proxyV2(1, 1, make([]byte, 8))},
+		{"v2 short inet6", proxyV2(1, 2, make([]byte, 20))},
+		{"v2 bad version", strings.Replace(proxyV2(1, 1, v2Inet4("192.0.2.10", 51000)), "\x21", "\x11", 1)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(c.header))); err == nil {
+				t.Errorf("accepted header, remote %v", addr)
+			}
+		})
+	}
+}
+
+// dialProxy sends header through a proxyListener trusting trusted and
+// returns what the server saw.
+func dialProxy(t *testing.T, trusted []netip.Prefix, header string) (remote string, data string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	pl := newProxyListener(ln, trusted)
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, header+"payload")
+	}()
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	remote = conn.RemoteAddr().String()
+	b, _ := io.ReadAll(conn)
+	return remote, string(b)
+}
+
+func TestProxyListenerTrustedPeer(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	remote, data := dialProxy(t, trusted, "PROXY TCP4 192.0.2.10 10.0.0.1 51000 7000\r\n")
+	if remote != "192.0.2.10:51000" {
+		t.Errorf("remote = %s, want 192.0.2.10:51000", remote)
+	}
+	if data != "payload" {
+		t.Errorf("data = %q, want %q", data, "payload")
+	}
+
+	remote, _ = dialProxy(t, trusted, proxyV2(0, 0, nil))
+	if ip, _, _ := net.SplitHostPort(remote); ip != "127.0.0.1" {
+		t.Errorf("remote of a LOCAL header = %s, want the proxy's address", remote)
+	}
+}
+
+func TestProxyListenerUntrustedPeer(t *testing.T) {
+	header := "PROXY TCP4 192.0.2.10 10.0.0.1 51000 7000\r\n"
+	for _, trusted := range [][]netip.Prefix{nil, {netip.MustParsePrefix("10.0.0.0/8")}} {
+		remote, data := dialProxy(t, trusted, header)
+		if ip, _, _ := net.SplitHostPort(remote); ip != "127.0.0.1" {
+			t.Errorf("trusted %v: remote = %s, want the peer's address", trusted, remote)
+		}
+		if data != header+"payload" {
+			t.Errorf("trusted %v: header was consumed, data = %q", trusted, data)
+		}
+	}
+}
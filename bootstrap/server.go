@@ -2,61 +2,393 @@ package bootstrap
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/stupidrun/mon/alerts"
 	"github.com/stupidrun/mon/api/middlewares"
 	"github.com/stupidrun/mon/api/proto"
 	"github.com/stupidrun/mon/config"
 	"github.com/stupidrun/mon/models"
+	"github.com/stupidrun/mon/notify"
 	"github.com/stupidrun/mon/services"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 	"log"
+	"maps"
+	"math"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-var store *models.MetricsStore
+func NewStore(c *config.Config) (models.Store, error) {
+	store, err := openStore(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.AllowedNamesFile != "" {
+		if err := seedAllowedNames(store, c.AllowedNamesFile); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func openStore(c *config.Config) (models.Store, error) {
+	retention, err := models.ParseRetention(c.RetentionRaw, c.RetentionTiers)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.StorageBackend {
+	case "", "memory":
+		// Samples stay in memory, but the allowlist must survive restarts.
+		path, err := dataFile(c, "", "allowlist.json")
+		if err != nil {
+			return nil, err
+		}
+		return models.OpenMemoryStore(path, retention)
+	case "disk":
+		return models.OpenDiskStore(
+			c.DataDir,
+			retention,
+			time.Duration(c.WalSyncIntervalSec)*time.Second,
+			time.Duration(c.SnapshotIntervalMin)*time.Minute,
+		)
+	case "sqlite":
+		path, err := dataFile(c, c.SQLitePath, "mon.db")
+		if err != nil {
+			return nil, err
+		}
+		return models.OpenSQLiteStore(path, retention)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", c.StorageBackend)
+	}
+}
+
+// NewAgentTokens opens the per-agent token file, which defaults to
+// agent_tokens.json in the data dir.
+func NewAgentTokens(c *config.Config) (*models.TokenStore, error) {
+	path, err := dataFile(c, c.AgentTokensFile, "agent_tokens.json")
+	if err != nil {
+		return nil, err
+	}
+	return models.OpenTokenStore(path, "mon_")
+}
+
+// NewAPIKeys opens the HTTP API key file, which defaults to api_keys.json in
+// the data dir.
+func NewAPIKeys(c *config.Config) (*models.TokenStore, error) {
+	path, err := dataFile(c, c.APIKeysFile, "api_keys.json")
+	if err != nil {
+		return nil, err
+	}
+	return models.OpenTokenStore(path, "monk_")
+}
+
+// NewAuditLog opens the audit log, which defaults to audit.log in the data
+// dir.
+func NewAuditLog(c *config.Config) (*models.AuditLog, error) {
+	path, err := dataFile(c, c.AuditLogFile, "audit.log")
+	if err != nil {
+		return nil, err
+	}
+	return models.OpenAuditLog(path)
+}
+
+// NewAlertEngine loads the alert rules. They are kept in the store when it
+// can hold them, as the SQLite store does, and otherwise in a file that
+// defaults to alert_rules.json in the data dir. Rules found in that file are
+// imported into an empty store once.
+func NewAlertEngine(c *config.Config, store models.Store) (*alerts.Engine, error) {
+	path, err := dataFile(c, c.AlertRulesFile, "alert_rules.json")
+	if err != nil {
+		return nil, err
+	}
+	file := alerts.NewRuleFile(path)
+	rs, ok := store.(alerts.RuleStore)
+	if !ok {
+		return alerts.NewEngine(file)
+	}
+
+	rules, err := rs.LoadAlertRules()
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		rules, err = file.LoadAlertRules()
+		if err != nil {
+			return nil, err
+		}
+		if len(rules) > 0 {
+			if err := rs.SaveAlertRules(rules); err != nil {
+				return nil, err
+			}
+			log.Printf("Imported %d alert rules from %s into the store", len(rules), path)
+		}
+	}
+	return alerts.NewEngine(rs)
+}
+
+// NewSilencer loads the silences, maintenance windows and host tags, which
+// default to silences.json in the data dir.
+func NewSilencer(c *config.Config) (*alerts.Silencer, error) {
+	path, err := dataFile(c, c.SilencesFile, "silences.json")
+	if err != nil {
+		return nil, err
+	}
+	return alerts.NewSilencer(path)
+}
+
+// NewEventHistory opens the event history, which defaults to events.log in
+// the data dir.
+func NewEventHistory(c *config.Config) (*alerts.History, error) {
+	path, err := dataFile(c, c.EventsFile, "events.log")
+	if err != nil {
+		return nil, err
+	}
+	return alerts.OpenHistory(path, eventHistoryLimit)
+}
+
+// eventHistoryLimit is how many events /api/events can look back on.
+const eventHistoryLimit = 1000
+
+// NewNotifiers builds the notifiers named in the comma-separated NOTIFIERS
+// setting.
+func NewNotifiers(c *config.Config) ([]alerts.Notifier, error) {
+	var notifiers []alerts.Notifier
+	for _, name := range strings.Split(c.Notifiers, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "log":
+			notifiers = append(notifiers, alerts.LogNotifier{})
+		default:
+			return nil, fmt.Errorf("unknown notifier: %s", name)
+		}
+	}
+	return notifiers, nil
+}
+
+// NewNotifyChannels sets up the channels listed in the channels file, which
+// defaults to notify_channels.json in the data dir. Each channel gets its
+// own rate limit.
+func NewNotifyChannels(c *config.Config) ([]*notify.Channel, error) {
+	path, err := dataFile(c, c.NotifyChannelsFile, "notify_channels.json")
+	if err != nil {
+		return nil, err
+	}
+	configs, err := notify.LoadConfigs(path)
+	if err != nil {
+		return nil, fmt.Errorf("notify channels: %w", err)
+	}
+	channels := make([]*notify.Channel, 0, len(configs))
+	for _, nc := range configs {
+		ch, err := notify.NewChannel(nc, middlewares.NewRateLimiter(nc.Rate()))
+		if err != nil {
+			return nil, fmt.Errorf("notify channel %s: %w", nc.Name, err)
+		}
+		channels = append(channels, ch)
+	}
+	return channels, nil
+}
+
+// dataFile returns path, or name inside the data dir if path is empty.
+func dataFile(c *config.Config, path, name string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	if err := os.MkdirAll(c.DataDir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(c.DataDir, name), nil
+}
+
+// seedAllowedNames adds every name listed in path to the allowlist. The file
+// holds one name per line, optionally followed by the CIDRs it may push
+// from, e.g. "web-01 10.0.1.0/24 10.0.2.7". Blank lines and lines starting
+// with # are ignored.
+func seedAllowedNames(store models.Store, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read allowed names file: %w", err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		cidrs, err := models.ParseCIDRs(fields[1:])
+		if err != nil {
+			return fmt.Errorf("allowed names file line %d: %w", i+1, err)
+		}
+		store.AddAllowedName(fields[0])
+		if len(cidrs) > 0 {
+			store.SetAllowedCIDRs(fields[0], cidrs)
+		}
+	}
+	return nil
+}
 
-func init() {
-	store = models.NewMetricsStore()
+// TrustedProxies parses TRUSTED_PROXY_CIDRS, a comma separated list of the
+// proxies allowed to forward client addresses.
+func TrustedProxies(c *config.Config) ([]netip.Prefix, error) {
+	trusted, err := models.ParseCIDRs(strings.Split(c.TrustedProxyCIDRs, ","))
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxy cidrs: %w", err)
+	}
+	return trusted, nil
 }
 
-func Store() *models.MetricsStore {
-	return store
+// unaryInterceptors returns the interceptor chain of the gRPC server.
+// peerIdentity binds names to client certificates in mTLS mode.
+func unaryInterceptors(c *config.Config, trusted []netip.Prefix, tokens *models.TokenStore, counter middlewares.Counter, peerIdentity bool) []grpc.UnaryServerInterceptor {
+	interceptors := []grpc.UnaryServerInterceptor{
+		middlewares.IPExtractorInterceptor(trusted),
+		middlewares.IPRateLimitInterceptor(middlewares.NewRateLimiter(c.IPRatePerMin, c.IPRateBurst), counter),
+		middlewares.TokenAuthInterceptor(c.IngestToken, tokens),
+	}
+	if peerIdentity {
+		interceptors = append(interceptors, middlewares.PeerIdentityInterceptor)
+	}
+	// Names are limited last, once the token and certificate bindings are
+	// known, so that an agent cannot drain the bucket of another host.
+	return append(interceptors, middlewares.NameRateLimitInterceptor(middlewares.NewRateLimiter(c.NameRatePerMin, c.NameRateBurst), counter))
 }
 
-func Serve(ctx context.Context, c *config.Config) error {
-	server := grpc.NewServer(grpc.UnaryInterceptor(middlewares.IPExtractorInterceptor))
+func Serve(ctx context.Context, c *config.Config, store models.Store, tokens *models.TokenStore, ips *models.IPTracker, self *models.SelfMetrics, alertEngine *alerts.Engine) error {
+	trusted, err := TrustedProxies(c)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := GrpcTLSConfig(c)
+	if err != nil {
+		return err
+	}
+	interceptors := unaryInterceptors(c, trusted, tokens, self, tlsConfig != nil && tlsConfig.ClientCAs != nil)
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.StreamInterceptor(middlewares.TokenAuthStreamInterceptor(c.IngestToken, tokens)),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(opts...)
 	if c.Debug {
 		log.Println("Debug mode is enabled")
 		log.Printf("Auth Token: %s", c.AuthToken)
+		log.Printf("Ingest Token: %s", c.IngestToken)
 		log.Printf("Cleanup Interval: %d hours", c.CleanupIntervalHours)
 		log.Printf("Offline Threshold: %d seconds", c.OfflineThresholdSec)
+		log.Printf("IP Conflict Window: %d seconds", c.IPConflictWindowSec)
 		log.Printf("gRPC Port: %s", c.GrpcPort)
+		log.Printf("gRPC Unix Socket: %s", c.GrpcUnixSocket)
+		log.Printf("PROXY Protocol: %t", c.ProxyProtocol)
+		log.Printf("Trusted Proxies: %s", c.TrustedProxyCIDRs)
+		log.Printf("TLS: %t", tlsConfig != nil)
+		log.Printf("Client CA File: %s", c.TLSClientCAFile)
+		log.Printf("Storage Backend: %s", c.StorageBackend)
+		log.Printf("Data Dir: %s", c.DataDir)
+		log.Printf("Allowed Names File: %s", c.AllowedNamesFile)
+		log.Printf("Agent Tokens File: %s", c.AgentTokensFile)
+		log.Printf("Retention: raw=%s tiers=%s", c.RetentionRaw, c.RetentionTiers)
+		log.Printf("Notifiers: %s, presence checked every %d seconds", c.Notifiers, c.PresenceIntervalSec)
+		log.Printf("Rate Limits: ip=%d/min burst %d, name=%d/min burst %d", c.IPRatePerMin, c.IPRateBurst, c.NameRatePerMin, c.NameRateBurst)
+		log.Printf("API Rate Limits: ip=%d/min burst %d, key=%d/min burst %d", c.APIIPRatePerMin, c.APIIPRateBurst, c.APIRatePerMin, c.APIRateBurst)
 		reflection.Register(server)
 	}
 
-	monSrv := services.NewMonitoringService(store, c.Debug)
+	monSrv := services.NewMonitoringService(store, ips, alertEngine, c.Debug)
 	proto.RegisterMonitoringServiceServer(server, monSrv)
 	lis, err := net.Listen("tcp", c.GrpcPort)
 	if err != nil {
 		return err
 	}
+	if c.ProxyProtocol {
+		if len(trusted) == 0 {
+			lis.Close()
+			return errors.New("PROXY_PROTOCOL requires TRUSTED_PROXY_CIDRS")
+		}
+		lis = newProxyListener(lis, trusted)
+	}
+	if c.GrpcUnixSocket != "" {
+		// A socket left behind by a crashed server would make Listen fail.
+		os.Remove(c.GrpcUnixSocket)
+		unixLis, err := net.Listen("unix", c.GrpcUnixSocket)
+		if err != nil {
+			lis.Close()
+			return err
+		}
+		log.Println("Starting gRPC server on", c.GrpcUnixSocket)
+		go func() {
+			if err := server.Serve(unixLis); err != nil {
+				log.Printf("gRPC unix socket server failed: %v", err)
+			}
+		}()
+	}
 
+	stopped := make(chan struct{})
 	go func() {
 		<-ctx.Done()
 		log.Println("Shutting down gRPC server...")
+		// Streams that never end would hold GracefulStop forever.
+		timer := time.AfterFunc(grpcStopTimeout, server.Stop)
 		server.GracefulStop()
+		timer.Stop()
 		log.Println("gRPC server stopped")
+		close(stopped)
 	}()
 
 	log.Println("Starting gRPC server on", c.GrpcPort)
-	return server.Serve(lis)
+	err = server.Serve(lis)
+	// Serve returns as soon as the listeners close; callers expect in-flight
+	// calls to have finished too.
+	if ctx.Err() != nil {
+		<-stopped
+	}
+	return err
 }
 
-func WebApi(engine *gin.Engine, cfg *config.Config) {
+// grpcStopTimeout bounds how long shutdown waits for in-flight calls.
+const grpcStopTimeout = 10 * time.Second
+
+func WebApi(engine *gin.Engine, cfg *config.Config, store models.Store, tokens, keys *models.TokenStore, ips *models.IPTracker, self *models.SelfMetrics, audit *models.AuditLog, alertEngine *alerts.Engine, events *alerts.History, dispatcher *alerts.Dispatcher, channels []*notify.Channel, silencer *alerts.Silencer) {
+	trusted, err := TrustedProxies(cfg)
+	if err != nil {
+		log.Printf("Ignoring invalid trusted proxies: %v", err)
+	}
+	proxies := make([]string, len(trusted))
+	for i, p := range trusted {
+		proxies[i] = p.String()
+	}
+	if err := engine.SetTrustedProxies(proxies); err != nil {
+		log.Printf("Failed to set trusted proxies: %v", err)
+	}
+	// The store was opened with the same retention, so it parses here too.
+	retention, err := models.ParseRetention(cfg.RetentionRaw, cfg.RetentionTiers)
+	if err != nil {
+		log.Printf("Ignoring invalid retention: %v", err)
+	}
+
 	g := engine.Group("/api")
-	g.Use(authMiddleware(config.LoadConfig().AuthToken))
-	g.POST("/allowed-names", func(c *gin.Context) {
+	g.Use(ipRateLimitMiddleware(middlewares.NewRateLimiter(cfg.APIIPRatePerMin, cfg.APIIPRateBurst), self))
+	g.Use(auditMiddleware(audit))
+	g.Use(authMiddleware(cfg.AuthToken, keys))
+	g.Use(rateLimitMiddleware(middlewares.NewRateLimiter(cfg.APIRatePerMin, cfg.APIRateBurst), self))
+	viewer := requireRole(models.RoleViewer)
+	operator := requireRole(models.RoleOperator)
+	admin := requireRole(models.RoleAdmin)
+
+	g.POST("/allowed-names", operator, func(c *gin.Context) {
 		var req struct {
 			Names []string `json:"names" binding:"required"`
 		}
@@ -76,7 +408,7 @@ func WebApi(engine *gin.Engine, cfg *config.Config) {
 		})
 	})
 
-	g.GET("/all-metrics", func(c *gin.Context) {
+	g.GET("/all-metrics", viewer, func(c *gin.Context) {
 		metrics := store.GetAllMetrics()
 		c.JSON(200, gin.H{
 			"success": true,
@@ -84,7 +416,7 @@ func WebApi(engine *gin.Engine, cfg *config.Config) {
 		})
 	})
 
-	g.DELETE("/allowed-names", func(c *gin.Context) {
+	g.DELETE("/allowed-names", admin, func(c *gin.Context) {
 		var req struct {
 			Names []string `json:"names" binding:"required"`
 		}
@@ -94,6 +426,15 @@ func WebApi(engine *gin.Engine, cfg *config.Config) {
 		}
 		for _, name := range req.Names {
 			store.RemoveName(name)
+			ips.Forget(name)
+			alertEngine.Forget(name)
+			dispatcher.Forget(name)
+			if err := silencer.Forget(name); err != nil {
+				log.Printf("Failed to drop tags of %s: %v", name, err)
+			}
+			if _, err := tokens.RevokeName(name); err != nil {
+				log.Printf("Failed to revoke agent tokens of %s: %v", name, err)
+			}
 		}
 		c.JSON(200, gin.H{
 			"success": true,
@@ -101,14 +442,526 @@ func WebApi(engine *gin.Engine, cfg *config.Config) {
 		})
 	})
 
-	g.GET("/alive", func(c *gin.Context) {
+	g.GET("/allowed-names/:name/cidrs", viewer, func(c *gin.Context) {
+		name := c.Param("name")
+		if !store.IsAllowed(name) {
+			c.JSON(404, gin.H{"error": "name not allowed"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"name":    name,
+			"cidrs":   store.AllowedCIDRs(name),
+		})
+	})
+
+	g.PUT("/allowed-names/:name/cidrs", operator, func(c *gin.Context) {
+		name := c.Param("name")
+		var req struct {
+			CIDRs []string `json:"cidrs"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		cidrs, err := models.ParseCIDRs(req.CIDRs)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if !store.IsAllowed(name) {
+			c.JSON(404, gin.H{"error": "name not allowed"})
+			return
+		}
+		store.SetAllowedCIDRs(name, cidrs)
+		c.JSON(200, gin.H{
+			"success": true,
+			"name":    name,
+			"cidrs":   store.AllowedCIDRs(name),
+		})
+	})
+
+	g.GET("/allowed-names/:name/tags", viewer, func(c *gin.Context) {
+		name := c.Param("name")
+		if !store.IsAllowed(name) {
+			c.JSON(404, gin.H{"error": "name not allowed"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"name":    name,
+			"tags":    silencer.Tags(name),
+		})
+	})
+
+	g.PUT("/allowed-names/:name/tags", operator, func(c *gin.Context) {
+		name := c.Param("name")
+		var req struct {
+			Tags []string `json:"tags"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		if !store.IsAllowed(name) {
+			c.JSON(404, gin.H{"error": "name not allowed"})
+			return
+		}
+		if err := silencer.SetTags(name, req.Tags); err != nil {
+			log.Printf("Failed to set tags of %s: %v", name, err)
+			c.JSON(500, gin.H{"error": "failed to set tags"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"name":    name,
+			"tags":    silencer.Tags(name),
+		})
+	})
+
+	g.POST("/agent-tokens", operator, func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		if !store.IsAllowed(req.Name) {
+			c.JSON(400, gin.H{"error": "name not allowed"})
+			return
+		}
+		t, token, err := tokens.Issue(req.Name, "")
+		if err != nil {
+			log.Printf("Failed to issue agent token for %s: %v", req.Name, err)
+			c.JSON(500, gin.H{"error": "failed to issue token"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success":    true,
+			"id":         t.ID,
+			"name":       t.Name,
+			"created_at": t.CreatedAt,
+			"token":      token,
+		})
+	})
+
+	g.GET("/agent-tokens", operator, func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"success": true,
+			"tokens":  tokens.List(c.Query("name")),
+		})
+	})
+
+	g.DELETE("/agent-tokens/:id", operator, func(c *gin.Context) {
+		ok, err := tokens.Revoke(c.Param("id"))
+		if err != nil {
+			log.Printf("Failed to revoke agent token %s: %v", c.Param("id"), err)
+			c.JSON(500, gin.H{"error": "failed to revoke token"})
+			return
+		}
+		if !ok {
+			c.JSON(404, gin.H{"error": "token not found"})
+			return
+		}
+		c.JSON(200, gin.H{"success": true})
+	})
+
+	g.POST("/keys", admin, func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name" binding:"required"`
+			Role string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		role, err := models.ParseRole(req.Role)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		k, key, err := keys.Issue(req.Name, role)
+		if err != nil {
+			log.Printf("Failed to create API key %s: %v", req.Name, err)
+			c.JSON(500, gin.H{"error": "failed to create key"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success":    true,
+			"id":         k.ID,
+			"name":       k.Name,
+			"role":       k.Role,
+			"created_at": k.CreatedAt,
+			"key":        key,
+		})
+	})
+
+	g.GET("/keys", admin, func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"success": true,
+			"keys":    keys.List(""),
+		})
+	})
+
+	g.DELETE("/keys/:id", admin, func(c *gin.Context) {
+		ok, err := keys.Revoke(c.Param("id"))
+		if err != nil {
+			log.Printf("Failed to revoke API key %s: %v", c.Param("id"), err)
+			c.JSON(500, gin.H{"error": "failed to revoke key"})
+			return
+		}
+		if !ok {
+			c.JSON(404, gin.H{"error": "key not found"})
+			return
+		}
+		c.JSON(200, gin.H{"success": true})
+	})
+
+	g.GET("/alive", viewer, func(c *gin.Context) {
 		result := store.AliveStatus(cfg.OfflineThresholdSec)
+		now := time.Now().UTC()
+		for name, v := range result {
+			state, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch {
+			case state["alive"] == true:
+				state["status"] = "online"
+			case silencer.InMaintenance(name, now):
+				state["status"] = "maintenance"
+			default:
+				state["status"] = "offline"
+			}
+			if status, ok := ips.Status(name); ok {
+				state["ip-conflict"] = status.Conflict
+				state["ips"] = slices.Sorted(maps.Keys(status.IPs))
+			}
+		}
 		c.JSON(200, gin.H{
 			"state": result,
 		})
 	})
 
-	g.GET("/metrics", func(c *gin.Context) {
+	g.GET("/alerts", viewer, func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"success": true,
+			"alerts":  alertEngine.Alerts(c.Query("state")),
+		})
+	})
+
+	g.GET("/alerts/rules", viewer, func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"success": true,
+			"rules":   alertEngine.Rules(),
+		})
+	})
+
+	g.GET("/alerts/rules/:id", viewer, func(c *gin.Context) {
+		rule, err := alertEngine.Rule(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"rule":    rule,
+		})
+	})
+
+	g.POST("/alerts/rules", operator, func(c *gin.Context) {
+		var req alerts.Rule
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		rule, err := alertEngine.AddRule(req)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"rule":    rule,
+		})
+	})
+
+	g.PUT("/alerts/rules/:id", operator, func(c *gin.Context) {
+		var req alerts.Rule
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		rule, err := alertEngine.UpdateRule(c.Param("id"), req)
+		if errors.Is(err, alerts.ErrRuleNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"rule":    rule,
+		})
+	})
+
+	g.DELETE("/alerts/rules/:id", operator, func(c *gin.Context) {
+		err := alertEngine.DeleteRule(c.Param("id"))
+		if errors.Is(err, alerts.ErrRuleNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to delete alert rule %s: %v", c.Param("id"), err)
+			c.JSON(500, gin.H{"error": "failed to delete rule"})
+			return
+		}
+		c.JSON(200, gin.H{"success": true})
+	})
+
+	g.GET("/silences", viewer, func(c *gin.Context) {
+		silences := silencer.Silences()
+		if c.Query("active") == "true" {
+			now := time.Now().UTC()
+			silences = slices.DeleteFunc(silences, func(s alerts.Silence) bool { return !s.Active(now) })
+		}
+		c.JSON(200, gin.H{
+			"success":  true,
+			"silences": silences,
+		})
+	})
+
+	g.POST("/silences", operator, func(c *gin.Context) {
+		var req struct {
+			alerts.Silence
+			// Duration may be given instead of ends_at, e.g. "2h".
+			Duration string `json:"duration"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		sl := req.Silence
+		if req.Duration != "" {
+			d, err := models.ParseDuration(req.Duration)
+			if err != nil || d <= 0 {
+				c.JSON(400, gin.H{"error": "invalid duration"})
+				return
+			}
+			if sl.StartsAt == 0 {
+				sl.StartsAt = time.Now().UTC().Unix()
+			}
+			sl.EndsAt = sl.StartsAt + int64(d/time.Second)
+		}
+		sl.CreatedBy = c.GetString(ctxKeyID)
+		if sl.CreatedBy == "" {
+			sl.CreatedBy = "auth-token"
+		}
+		sl, err := silencer.AddSilence(sl)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"silence": sl,
+		})
+	})
+
+	g.DELETE("/silences/:id", operator, func(c *gin.Context) {
+		err := silencer.DeleteSilence(c.Param("id"))
+		if errors.Is(err, alerts.ErrSilenceNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to delete silence %s: %v", c.Param("id"), err)
+			c.JSON(500, gin.H{"error": "failed to delete silence"})
+			return
+		}
+		c.JSON(200, gin.H{"success": true})
+	})
+
+	g.GET("/maintenance-windows", viewer, func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"success": true,
+			"windows": silencer.Windows(),
+		})
+	})
+
+	g.POST("/maintenance-windows", operator, func(c *gin.Context) {
+		var req alerts.Window
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		w, err := silencer.AddWindow(req)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"window":  w,
+		})
+	})
+
+	g.PUT("/maintenance-windows/:id", operator, func(c *gin.Context) {
+		var req alerts.Window
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+		w, err := silencer.UpdateWindow(c.Param("id"), req)
+		if errors.Is(err, alerts.ErrWindowNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"window":  w,
+		})
+	})
+
+	g.DELETE("/maintenance-windows/:id", operator, func(c *gin.Context) {
+		err := silencer.DeleteWindow(c.Param("id"))
+		if errors.Is(err, alerts.ErrWindowNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to delete maintenance window %s: %v", c.Param("id"), err)
+			c.JSON(500, gin.H{"error": "failed to delete window"})
+			return
+		}
+		c.JSON(200, gin.H{"success": true})
+	})
+
+	g.GET("/audit", admin, func(c *gin.Context) {
+		filter := models.AuditFilter{
+			Key:   c.Query("key"),
+			Route: c.Query("route"),
+		}
+		var err error
+		if filter.From, err = queryInt64(c, "from", 0); err != nil {
+			c.JSON(400, gin.H{"error": "invalid from parameter"})
+			return
+		}
+		if filter.To, err = queryInt64(c, "to", 0); err != nil {
+			c.JSON(400, gin.H{"error": "invalid to parameter"})
+			return
+		}
+		limit, err := queryInt64(c, "limit", 100)
+		if err != nil || limit < 0 {
+			c.JSON(400, gin.H{"error": "invalid limit parameter"})
+			return
+		}
+		filter.Limit = int(limit)
+		entries, err := audit.Query(filter)
+		if err != nil {
+			log.Printf("Failed to read audit log: %v", err)
+			c.JSON(500, gin.H{"error": "failed to read audit log"})
+			return
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"entries": entries,
+		})
+	})
+
+	g.GET("/events", viewer, func(c *gin.Context) {
+		filter := alerts.EventFilter{
+			Kind: c.Query("kind"),
+			Host: c.Query("host"),
+		}
+		var err error
+		if filter.From, err = queryInt64(c, "from", 0); err != nil {
+			c.JSON(400, gin.H{"error": "invalid from parameter"})
+			return
+		}
+		if filter.To, err = queryInt64(c, "to", 0); err != nil {
+			c.JSON(400, gin.H{"error": "invalid to parameter"})
+			return
+		}
+		limit, err := queryInt64(c, "limit", 100)
+		if err != nil || limit < 0 {
+			c.JSON(400, gin.H{"error": "invalid limit parameter"})
+			return
+		}
+		filter.Limit = int(limit)
+		c.JSON(200, gin.H{
+			"success": true,
+			"events":  events.Query(filter),
+		})
+	})
+
+	g.GET("/notify/channels", viewer, func(c *gin.Context) {
+		list := make([]gin.H, len(channels))
+		for i, ch := range channels {
+			list[i] = gin.H{"name": ch.Name(), "type": ch.Type()}
+		}
+		c.JSON(200, gin.H{
+			"success":  true,
+			"channels": list,
+		})
+	})
+
+	g.POST("/notify/channels/:name/test", operator, func(c *gin.Context) {
+		i := slices.IndexFunc(channels, func(ch *notify.Channel) bool { return ch.Name() == c.Param("name") })
+		if i < 0 {
+			c.JSON(404, gin.H{"error": "channel not found"})
+			return
+		}
+		e := alerts.Event{
+			Kind:     "test",
+			Host:     "test",
+			Time:     time.Now().UTC().Unix(),
+			Severity: alerts.SeverityInfo,
+			Message:  "Test notification from mon",
+		}
+		if err := channels[i].Test(e); err != nil {
+			c.JSON(502, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"success": true})
+	})
+
+	g.GET("/self-metrics", viewer, func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"success":  true,
+			"counters": self.Snapshot(),
+		})
+	})
+
+	g.GET("/ips", viewer, func(c *gin.Context) {
+		conflictsOnly := c.Query("conflicts") == "true"
+		hosts := make(map[string]models.IPStatus)
+		for name, status := range ips.All() {
+			if n := c.Query("name"); n != "" && n != name {
+				continue
+			}
+			if conflictsOnly && !status.Conflict {
+				continue
+			}
+			hosts[name] = status
+		}
+		c.JSON(200, gin.H{
+			"success": true,
+			"hosts":   hosts,
+		})
+	})
+
+	// /metrics returns samples in the shape pushed by the agents. A range
+	// whose from lies beyond the raw retention is answered from the rollup
+	// tiers; step then reports the tier width, and is 0 for raw samples.
+	g.GET("/metrics", viewer, func(c *gin.Context) {
 		name := c.Query("name")
 		if name == "" {
 			c.JSON(400, gin.H{"error": "name query parameter is required"})
@@ -118,7 +971,36 @@ func WebApi(engine *gin.Engine, cfg *config.Config) {
 			c.JSON(400, gin.H{"error": "name not allowed"})
 			return
 		}
-		metrics := store.GetMetrics(name)
+		from, err := queryInt64(c, "from", 0)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid from parameter"})
+			return
+		}
+		to, err := queryInt64(c, "to", math.MaxInt64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid to parameter"})
+			return
+		}
+		step, err := queryInt64(c, "step", 0)
+		if err != nil || step < 0 {
+			c.JSON(400, gin.H{"error": "invalid step parameter"})
+			return
+		}
+		limit, err := queryInt64(c, "limit", 0)
+		if err != nil || limit < 0 {
+			c.JSON(400, gin.H{"error": "invalid limit parameter"})
+			return
+		}
+		// Raw samples only reach back RETENTION_RAW. Older ranges are read
+		// from the rollup tiers, which hold the bucket averages.
+		var metrics []models.Metric
+		var seriesStep int64
+		if c.Query("from") != "" && len(retention.Tiers) > 0 && from < time.Now().UTC().Add(-retention.Raw).Unix() {
+			series := store.Query(name, from, to)
+			metrics, seriesStep = series.Metrics(step, int(limit)), series.Step
+		} else {
+			metrics = store.GetMetricsRange(name, from, to, step, int(limit))
+		}
 		if metrics == nil {
 			c.JSON(404, gin.H{"error": "no metrics found for this name"})
 			return
@@ -126,28 +1008,179 @@ func WebApi(engine *gin.Engine, cfg *config.Config) {
 		c.JSON(200, gin.H{
 			"success": true,
 			"name":    name,
+			"step":    seriesStep,
 			"metrics": metrics,
 		})
 	})
-}
 
-func authMiddleware(token string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(401, gin.H{
-				"success": false,
-				"message": "unauthorized",
-			})
+	g.GET("/history", viewer, func(c *gin.Context) {
+		name := c.Query("name")
+		if name == "" {
+			c.JSON(400, gin.H{"error": "name query parameter is required"})
+			return
+		}
+		if !store.IsAllowed(name) {
+			c.JSON(400, gin.H{"error": "name not allowed"})
+			return
+		}
+		to, err := queryInt64(c, "to", time.Now().UTC().Unix())
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid to parameter"})
+			return
+		}
+		from, err := queryInt64(c, "from", to-3600)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid from parameter"})
 			return
 		}
-		if authHeader != token {
-			c.AbortWithStatusJSON(403, gin.H{
-				"success": false,
-				"message": "forbidden",
+		series := store.Query(name, from, to)
+		c.JSON(200, gin.H{
+			"success": true,
+			"name":    name,
+			"step":    series.Step,
+			"points":  series.Points,
+		})
+	})
+	g.GET("/metrics/aggregate", viewer, func(c *gin.Context) {
+		agg, err := models.NewAggregation(c.DefaultQuery("field", "cpu"), c.DefaultQuery("fn", "avg"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		window, err := models.ParseDuration(c.DefaultQuery("window", "1h"))
+		if err != nil || window <= 0 {
+			c.JSON(400, gin.H{"error": "invalid window parameter"})
+			return
+		}
+		to := time.Now().UTC().Unix()
+		from := to - int64(window/time.Second)
+
+		name := c.Query("name")
+		if name != "" {
+			if !store.IsAllowed(name) {
+				c.JSON(400, gin.H{"error": "name not allowed"})
+				return
+			}
+			metrics := store.GetMetricsRange(name, from, to, 0, 0)
+			value, ok := agg.Apply(metrics)
+			if !ok {
+				c.JSON(404, gin.H{"error": "no metrics found in this window"})
+				return
+			}
+			c.JSON(200, gin.H{
+				"success": true,
+				"name":    name,
+				"field":   agg.Field,
+				"fn":      agg.Func,
+				"window":  window.String(),
+				"samples": len(metrics),
+				"value":   value,
 			})
 			return
 		}
-		c.Next()
+
+		// Without a name the statistic is computed per host, summed across
+		// the fleet and also computed over the pooled samples of every host.
+		hosts := make(map[string]float64)
+		var total float64
+		var pooled []models.Metric
+		for _, n := range store.GetAllowedNames() {
+			metrics := store.GetMetricsRange(n, from, to, 0, 0)
+			value, ok := agg.Apply(metrics)
+			if !ok {
+				continue
+			}
+			hosts[n] = value
+			total += value
+			pooled = append(pooled, metrics...)
+		}
+		overall, _ := agg.Apply(pooled)
+		c.JSON(200, gin.H{
+			"success": true,
+			"field":   agg.Field,
+			"fn":      agg.Func,
+			"window":  window.String(),
+			"samples": len(pooled),
+			"hosts":   hosts,
+			"total":   total,
+			"overall": overall,
+		})
+	})
+
+	g.GET("/metrics/quantile", viewer, func(c *gin.Context) {
+		metric := c.Query("metric")
+		if metric == "" {
+			c.JSON(400, gin.H{"error": "metric query parameter is required"})
+			return
+		}
+		q, err := strconv.ParseFloat(c.DefaultQuery("q", "0.95"), 64)
+		if err != nil || math.IsNaN(q) || q < 0 || q > 1 {
+			c.JSON(400, gin.H{"error": "invalid q parameter"})
+			return
+		}
+		window, err := models.ParseDuration(c.DefaultQuery("window", "1h"))
+		if err != nil || window <= 0 {
+			c.JSON(400, gin.H{"error": "invalid window parameter"})
+			return
+		}
+		to := time.Now().UTC().Unix()
+		from := to - int64(window/time.Second)
+
+		names := store.GetAllowedNames()
+		if name := c.Query("name"); name != "" {
+			if !store.IsAllowed(name) {
+				c.JSON(400, gin.H{"error": "name not allowed"})
+				return
+			}
+			names = []string{name}
+		}
+
+		var histograms []*models.Histogram
+		hosts := make(map[string]float64)
+		summaries := make(map[string]map[string]*models.Summary)
+		for _, n := range names {
+			metrics := store.GetMetricsRange(n, from, to, 0, 0)
+			if h := models.WindowHistogram(metrics, metric); h != nil && h.Count > 0 {
+				histograms = append(histograms, h)
+				// A histogram with only the +Inf bucket has no finite
+				// estimate, and NaN cannot be encoded as JSON.
+				if v := h.Quantile(q); !math.IsNaN(v) {
+					hosts[n] = v
+				}
+			}
+			if s := models.LatestSummaries(metrics, metric); len(s) > 0 {
+				summaries[n] = s
+			}
+		}
+		if len(histograms) == 0 && len(summaries) == 0 {
+			c.JSON(404, gin.H{"error": "no histogram or summary samples found in this window"})
+			return
+		}
+
+		resp := gin.H{
+			"success":   true,
+			"metric":    metric,
+			"q":         q,
+			"window":    window.String(),
+			"hosts":     hosts,
+			"summaries": summaries,
+		}
+		if len(histograms) > 0 {
+			merged := models.MergeHistograms(histograms...)
+			resp["value"] = nil
+			if v := merged.Quantile(q); !math.IsNaN(v) {
+				resp["value"] = v
+			}
+			resp["count"] = merged.Count
+		}
+		c.JSON(200, resp)
+	})
+}
+
+func queryInt64(c *gin.Context, key string, defaultVal int64) (int64, error) {
+	value := c.Query(key)
+	if value == "" {
+		return defaultVal, nil
 	}
+	return strconv.ParseInt(value, 10, 64)
 }
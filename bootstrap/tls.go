@@ -0,0 +1,108 @@
+package bootstrap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/stupidrun/mon/config"
+	"os"
+	"strings"
+)
+
+// ServerTLSConfig returns the TLS configuration shared by the gRPC and HTTP
+// listeners, or nil when no certificate is configured.
+func ServerTLSConfig(c *config.Config) (*tls.Config, error) {
+	if c.TLSCertFile == "" && c.TLSKeyFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS key pair: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// GrpcTLSConfig extends ServerTLSConfig for the ingest listener. When a
+// client CA is configured agents must present a certificate signed by it
+// (mTLS), whose CN or DNS SANs are then checked against the pushed names.
+func GrpcTLSConfig(c *config.Config) (*tls.Config, error) {
+	cfg, err := ServerTLSConfig(c)
+	if err != nil || cfg == nil || c.TLSClientCAFile == "" {
+		return cfg, err
+	}
+	pool, err := loadCertPool(c.TLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no valid certificates in CA file: %s", path)
+	}
+	return pool, nil
+}
+
+// ClientTLSConfig returns the TLS configuration of the agent. caFile is the
+// CA used to verify the server certificate. pin is the SHA-256 fingerprint
+// of the DER encoded server certificate, in hex with optional colons, for
+// self-signed setups; with a pin and no CA the chain is not verified and
+// only the fingerprint is compared. certFile and keyFile are the client
+// certificate presented when the server requires mTLS; its CN or a SAN must
+// match the agent name.
+func ClientTLSConfig(caFile, pin, serverName, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if pin != "" {
+		want, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+		if err != nil || len(want) != sha256.Size {
+			return nil, fmt.Errorf("invalid certificate pin: %s", pin)
+		}
+		cfg.InsecureSkipVerify = caFile == ""
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], want) {
+				return fmt.Errorf("server certificate fingerprint mismatch: %x", sum)
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
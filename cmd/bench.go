@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/stupidrun/mon/models"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bench drives the in-memory store with many concurrent agents while
+// dashboards poll GetAllMetrics, and reports push and poll latency. Run it
+// with different -agents values to see how ingest scales.
+func main() {
+	agents := flag.Int("agents", 2000, "Number of concurrent agents pushing metrics")
+	readers := flag.Int("readers", 4, "Number of concurrent dashboard pollers calling GetAllMetrics")
+	history := flag.Int("history", 100, "Samples preloaded per agent before measuring")
+	interval := flag.Duration("i", 10*time.Millisecond, "Push interval of every agent")
+	duration := flag.Duration("d", 5*time.Second, "How long to run the benchmark")
+	flag.Parse()
+
+	store := models.NewMetricsStore(models.DefaultRetention())
+	now := time.Now().UTC().Unix()
+	for i := 0; i < *agents; i++ {
+		name := fmt.Sprintf("agent-%d", i)
+		store.AddAllowedName(name)
+		for j := 0; j < *history; j++ {
+			store.AddMetric(name, models.Metric{Name: name, Timestamp: now - int64(*history-j)})
+		}
+	}
+
+	var pushes, pushNanos, pushMax, polls, pollNanos atomic.Int64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < *agents; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			m := models.Metric{Name: name, IP: "10.0.0.1", CPUUsage: 12.5, MemoryUsage: 2048}
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+				m.Timestamp = time.Now().UTC().Unix()
+				start := time.Now()
+				store.AddMetric(name, m)
+				took := int64(time.Since(start))
+				pushNanos.Add(took)
+				for {
+					cur := pushMax.Load()
+					if took <= cur || pushMax.CompareAndSwap(cur, took) {
+						break
+					}
+				}
+				pushes.Add(1)
+			}
+		}(fmt.Sprintf("agent-%d", i))
+	}
+
+	for i := 0; i < *readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				start := time.Now()
+				store.GetAllMetrics()
+				pollNanos.Add(int64(time.Since(start)))
+				polls.Add(1)
+			}
+		}()
+	}
+
+	time.Sleep(*duration)
+	close(done)
+	wg.Wait()
+
+	secs := duration.Seconds()
+	log.Printf("agents=%d readers=%d history=%d interval=%s duration=%s", *agents, *readers, *history, *interval, *duration)
+	if n := pushes.Load(); n > 0 {
+		log.Printf("AddMetric: %d pushes, %.0f pushes/s, avg %s, max %s",
+			n, float64(n)/secs, time.Duration(pushNanos.Load()/n), time.Duration(pushMax.Load()))
+	}
+	if n := polls.Load(); n > 0 {
+		log.Printf("GetAllMetrics: %d polls, avg %s", n, time.Duration(pollNanos.Load()/n))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/stupidrun/mon/bootstrap"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: go run cmd/ca.go [flags] <command>
+
+Commands:
+  init      create a new CA in -dir
+  agent     issue an agent client certificate for -n
+  server    issue a server certificate for -n and -hosts
+
+Flags:
+`
+
+func main() {
+	dir := flag.String("dir", "pki", "Directory holding the CA and issued certificates")
+	name := flag.String("n", "", "Agent name, or server host name for server certificates")
+	hosts := flag.String("hosts", "", "Comma separated extra DNS names or IPs for server certificates")
+	days := flag.Int("days", 365, "Certificate validity in days")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	validity := time.Duration(*days) * 24 * time.Hour
+	var err error
+	switch flag.Arg(0) {
+	case "init":
+		err = bootstrap.InitCA(*dir, 10*validity)
+	case "agent", "server":
+		if *name == "" {
+			log.Fatal("Certificate name must be provided using -n flag")
+		}
+		var extra []string
+		if *hosts != "" {
+			extra = strings.Split(*hosts, ",")
+		}
+		err = bootstrap.IssueCert(*dir, *name, extra, flag.Arg(0) == "server", validity)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s failed: %v", flag.Arg(0), err)
+	}
+	log.Printf("%s done, files written to %s", flag.Arg(0), *dir)
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"github.com/stupidrun/mon/bootstrap"
+	"github.com/stupidrun/mon/utils"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -15,20 +18,57 @@ import (
 func main() {
 	clientName := flag.String("n", "", "Name of the client")
 	interval := flag.Int("i", 10, "Metrics collection interval in seconds")
+	localAddr := flag.String("l", "", "Local address to accept samples from other services on, e.g. 127.0.0.1:37323")
+	token := flag.String("t", os.Getenv("MONITORING_AUTH_TOKEN"), "Auth token sent to the server, defaults to $MONITORING_AUTH_TOKEN")
+	useTLS := flag.Bool("tls", os.Getenv("MONITORING_TLS") == "true", "Connect over TLS using the system roots; implied by -ca, -pin and -cert")
+	caFile := flag.String("ca", os.Getenv("MONITORING_CA_FILE"), "CA certificate used to verify the server, defaults to $MONITORING_CA_FILE")
+	pin := flag.String("pin", os.Getenv("MONITORING_CERT_PIN"), "SHA-256 fingerprint of the server certificate for self-signed setups, defaults to $MONITORING_CERT_PIN")
+	serverName := flag.String("server-name", os.Getenv("MONITORING_SERVER_NAME"), "Server name expected in the server certificate, defaults to the host")
+	certFile := flag.String("cert", os.Getenv("MONITORING_CLIENT_CERT"), "Client certificate for mTLS, defaults to $MONITORING_CLIENT_CERT")
+	keyFile := flag.String("key", os.Getenv("MONITORING_CLIENT_KEY"), "Client certificate key for mTLS, defaults to $MONITORING_CLIENT_KEY")
+	address := flag.String("addr", os.Getenv("MONITORING_AGENT_ADDRESS"), "Address reported to the server when it sits behind a proxy, defaults to the outbound address towards the server")
 	flag.Parse()
 
 	if *clientName == "" {
 		log.Fatal("Client name must be provided using -n flag")
 	}
+	if *token == "" {
+		log.Fatal("Auth token must be provided using -t flag or MONITORING_AUTH_TOKEN")
+	}
+
+	var tlsConfig *tls.Config
+	if *useTLS || *caFile != "" || *pin != "" || *certFile != "" {
+		var err error
+		tlsConfig, err = bootstrap.ClientTLSConfig(*caFile, *pin, *serverName, *certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS config: %v", err)
+		}
+	}
 
-	serverHost := os.Getenv("MONITORING_SERVER_HOST")
-	client, err := bootstrap.NewClient(fmt.Sprintf("%s:37322", serverHost), *clientName)
+	serverAddr := os.Getenv("MONITORING_SERVER_ADDR")
+	if serverAddr == "" {
+		serverAddr = fmt.Sprintf("%s:37322", os.Getenv("MONITORING_SERVER_HOST"))
+	}
+	client, err := bootstrap.NewClient(serverAddr, *clientName, *token, tlsConfig)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 	}
+	if *address == "" && !strings.HasPrefix(serverAddr, "unix:") {
+		if ip, err := utils.GetOutboundIP(serverAddr); err == nil {
+			*address = ip
+		}
+	}
+	client.SetAddress(*address)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go bootstrap.StartPeriodicMetricsCollection(ctx, client, *interval)
+	if *localAddr != "" {
+		go func() {
+			if err := bootstrap.ServeLocalSamples(ctx, *localAddr, client); err != nil {
+				log.Printf("Local samples listener failed: %v", err)
+			}
+		}()
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGINT)
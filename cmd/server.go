@@ -4,12 +4,15 @@ import (
 	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/stupidrun/mon/alerts"
 	"github.com/stupidrun/mon/bootstrap"
 	"github.com/stupidrun/mon/config"
+	"github.com/stupidrun/mon/models"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -29,18 +32,98 @@ func corsMiddleware() gin.HandlerFunc {
 
 func main() {
 	cfg := config.LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	if cfg.IngestToken == "" {
+		log.Println("INGEST_TOKEN is not set, agents must use per-agent tokens")
+	}
+	store, err := bootstrap.NewStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open metrics store: %v", err)
+	}
+	defer store.Close()
+	tokens, err := bootstrap.NewAgentTokens(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open agent tokens: %v", err)
+	}
+	keys, err := bootstrap.NewAPIKeys(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open API keys: %v", err)
+	}
+	audit, err := bootstrap.NewAuditLog(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer audit.Close()
+	alertEngine, err := bootstrap.NewAlertEngine(cfg, store)
+	if err != nil {
+		log.Fatalf("Failed to load alert rules: %v", err)
+	}
+	history, err := bootstrap.NewEventHistory(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open event history: %v", err)
+	}
+	defer history.Close()
+	notifiers, err := bootstrap.NewNotifiers(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up notifiers: %v", err)
+	}
+	channels, err := bootstrap.NewNotifyChannels(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up notify channels: %v", err)
+	}
+	for _, ch := range channels {
+		notifiers = append(notifiers, ch)
+	}
+	silencer, err := bootstrap.NewSilencer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load silences: %v", err)
+	}
+	dispatcher := alerts.NewDispatcher(history, silencer, notifiers...)
+	defer dispatcher.Close()
+	alertEngine.OnTransition(func(a alerts.Alert) {
+		if e, ok := alerts.AlertEvent(a); ok {
+			dispatcher.Publish(e)
+		}
+	})
+	ips := models.NewIPTracker(time.Duration(cfg.IPConflictWindowSec) * time.Second)
+	self := models.NewSelfMetrics()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// The stores, the history and the dispatcher are closed by the deferred
+	// calls above, so every goroutine that uses them is tracked here and
+	// waited for first.
+	var wg sync.WaitGroup
+
+	wg.Add(1)
 	go func() {
-		err := bootstrap.Serve(ctx, cfg)
+		defer wg.Done()
+		err := bootstrap.Serve(ctx, cfg, store, tokens, ips, self, alertEngine)
 		if err != nil {
 			panic(err)
 		}
 	}()
 
+	presence := alerts.NewPresence(store, cfg.OfflineThresholdSec, silencer, dispatcher)
+	wg.Add(1)
 	go func() {
-		store := bootstrap.Store()
+		defer wg.Done()
+		presence.Run(ctx, time.Duration(cfg.PresenceIntervalSec)*time.Second)
+	}()
+
+	// Events muted by a silence are sent once it ends, if still relevant.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dispatcher.Run(ctx, time.Duration(cfg.PresenceIntervalSec)*time.Second)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
 		for {
 			select {
 			case <-time.After(time.Hour * time.Duration(cfg.CleanupIntervalHours)):
@@ -52,27 +135,41 @@ func main() {
 		}
 	}()
 
+	tlsConfig, err := bootstrap.ServerTLSConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load TLS config: %v", err)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.Default()
+	e.Use(corsMiddleware())
+	bootstrap.WebApi(e, cfg, store, tokens, keys, ips, self, audit, alertEngine, history, dispatcher, channels, silencer)
+	srv := http.Server{
+		Addr:      cfg.HTTPAddr,
+		Handler:   e,
+		TLSConfig: tlsConfig,
+	}
+
+	wg.Add(1)
 	go func() {
-		gin.SetMode(gin.ReleaseMode)
-		e := gin.Default()
-		e.Use(corsMiddleware())
-		bootstrap.WebApi(e, cfg)
-		srv := http.Server{
-			Addr:    ":7920",
-			Handler: e,
+		defer wg.Done()
+		<-ctx.Done()
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Printf("HTTP server shutdown failed: %v", err)
+		} else {
+			log.Println("HTTP server shutdown gracefully")
 		}
+	}()
 
-		log.Println("Starting HTTP server on :7920")
-		go func() {
-			<-ctx.Done()
-			if err := srv.Shutdown(context.Background()); err != nil {
-				log.Printf("HTTP server shutdown failed: %v", err)
-			} else {
-				log.Println("HTTP server shutdown gracefully")
-			}
-		}()
-
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	go func() {
+		log.Println("Starting HTTP server on", cfg.HTTPAddr)
+		var err error
+		if tlsConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("listen: %s\n", err)
 		}
 	}()
@@ -81,6 +178,7 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGINT)
 	<-sigChan
 	log.Println("Received shutdown signal, shutting down gracefully...")
-	<-time.After(5 * time.Second)
+	cancel()
+	wg.Wait()
 	log.Println("shutting down complete")
 }
@@ -1,29 +1,123 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"strconv"
+	"strings"
 )
 
+// defaultAuthToken is the public placeholder for AUTH_TOKEN. The server
+// refuses to start with it.
+const defaultAuthToken = "this_is_bullshit"
+
 type Config struct {
 	AuthToken            string
+	IngestToken          string
 	CleanupIntervalHours int
 	OfflineThresholdSec  int
+	IPConflictWindowSec  int
 	GrpcPort             string
+	HTTPAddr             string
+	GrpcUnixSocket       string
+	ProxyProtocol        bool
+	TrustedProxyCIDRs    string
+	TLSCertFile          string
+	TLSKeyFile           string
+	TLSClientCAFile      string
 	Debug                bool
+	StorageBackend       string
+	DataDir              string
+	SQLitePath           string
+	WalSyncIntervalSec   int
+	SnapshotIntervalMin  int
+	AllowedNamesFile     string
+	AgentTokensFile      string
+	APIKeysFile          string
+	AuditLogFile         string
+	AlertRulesFile       string
+	EventsFile           string
+	Notifiers            string
+	NotifyChannelsFile   string
+	SilencesFile         string
+	PresenceIntervalSec  int
+	RetentionRaw         string
+	RetentionTiers       string
+	IPRatePerMin         int
+	IPRateBurst          int
+	NameRatePerMin       int
+	NameRateBurst        int
+	APIRatePerMin        int
+	APIRateBurst         int
+	APIIPRatePerMin      int
+	APIIPRateBurst       int
 }
 
 func LoadConfig() *Config {
 	cleanupInterval, _ := strconv.Atoi(getEnv("CLEANUP_INTERVAL_HOURS", "1"))
 	offlineThreshold, _ := strconv.Atoi(getEnv("OFFLINE_THRESHOLD_SEC", "90"))
 
+	authToken := getEnv("AUTH_TOKEN", defaultAuthToken)
+
 	return &Config{
-		AuthToken:            getEnv("AUTH_TOKEN", "this_is_bullshit"),
+		AuthToken:            authToken,
+		IngestToken:          getEnv("INGEST_TOKEN", ""),
 		CleanupIntervalHours: cleanupInterval,
 		OfflineThresholdSec:  offlineThreshold,
+		IPConflictWindowSec:  getEnv("IP_CONFLICT_WINDOW_SEC", 300),
 		GrpcPort:             ":37322",
+		HTTPAddr:             ":7920",
+		GrpcUnixSocket:       getEnv("GRPC_UNIX_SOCKET", ""),
+		ProxyProtocol:        getEnv("PROXY_PROTOCOL", false),
+		TrustedProxyCIDRs:    getEnv("TRUSTED_PROXY_CIDRS", ""),
+		TLSCertFile:          getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:           getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile:      getEnv("TLS_CLIENT_CA_FILE", ""),
 		Debug:                getEnv("DEBUG", false),
+		StorageBackend:       getEnv("STORAGE_BACKEND", "memory"),
+		DataDir:              getEnv("DATA_DIR", "data"),
+		SQLitePath:           getEnv("SQLITE_PATH", ""),
+		WalSyncIntervalSec:   getEnv("WAL_SYNC_INTERVAL_SEC", 1),
+		SnapshotIntervalMin:  getEnv("SNAPSHOT_INTERVAL_MIN", 10),
+		AllowedNamesFile:     getEnv("ALLOWED_NAMES_FILE", ""),
+		AgentTokensFile:      getEnv("AGENT_TOKENS_FILE", ""),
+		APIKeysFile:          getEnv("API_KEYS_FILE", ""),
+		AuditLogFile:         getEnv("AUDIT_LOG_FILE", ""),
+		AlertRulesFile:       getEnv("ALERT_RULES_FILE", ""),
+		EventsFile:           getEnv("EVENTS_FILE", ""),
+		Notifiers:            getEnv("NOTIFIERS", "log"),
+		NotifyChannelsFile:   getEnv("NOTIFY_CHANNELS_FILE", ""),
+		SilencesFile:         getEnv("SILENCES_FILE", ""),
+		PresenceIntervalSec:  getEnv("PRESENCE_INTERVAL_SEC", 10),
+		RetentionRaw:         getEnv("RETENTION_RAW", "24h"),
+		RetentionTiers:       getEnv("RETENTION_TIERS", "1m:7d,1h:90d"),
+		IPRatePerMin:         getEnv("RATE_LIMIT_IP_PER_MIN", 600),
+		IPRateBurst:          getEnv("RATE_LIMIT_IP_BURST", 100),
+		NameRatePerMin:       getEnv("RATE_LIMIT_NAME_PER_MIN", 60),
+		NameRateBurst:        getEnv("RATE_LIMIT_NAME_BURST", 10),
+		APIRatePerMin:        getEnv("RATE_LIMIT_API_PER_MIN", 600),
+		APIRateBurst:         getEnv("RATE_LIMIT_API_BURST", 60),
+		APIIPRatePerMin:      getEnv("RATE_LIMIT_API_IP_PER_MIN", 600),
+		APIIPRateBurst:       getEnv("RATE_LIMIT_API_IP_BURST", 100),
+	}
+}
+
+// Validate rejects settings the server must not start with.
+func (c *Config) Validate() error {
+	// AUTH_TOKEN has the admin role, which can mint API keys and agent
+	// tokens, so a deployment must not run with a publicly known value.
+	if strings.TrimSpace(c.AuthToken) == "" || c.AuthToken == defaultAuthToken {
+		return errors.New("AUTH_TOKEN must be set to a secret value")
+	}
+	// AUTH_TOKEN is an admin credential and must never be handed to agents.
+	if c.IngestToken != "" && c.IngestToken == c.AuthToken {
+		return errors.New("INGEST_TOKEN must differ from AUTH_TOKEN")
+	}
+	// Without trusted proxies any peer could forge its address in a header.
+	if c.ProxyProtocol && strings.TrimSpace(c.TrustedProxyCIDRs) == "" {
+		return errors.New("PROXY_PROTOCOL requires TRUSTED_PROXY_CIDRS")
 	}
+	return nil
 }
 
 func getEnv[T string | int | bool](key string, defaultVal T) T {
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var metricFields = map[string]func(Metric) float64{
+	"cpu":         func(m Metric) float64 { return m.CPUUsage },
+	"memory":      func(m Metric) float64 { return m.MemoryUsage },
+	"network_in":  func(m Metric) float64 { return m.NetworkIn },
+	"network_out": func(m Metric) float64 { return m.NetworkOut },
+}
+
+// Aggregation computes one statistic (fn) over one field of a set of
+// samples, e.g. the p95 of cpu.
+type Aggregation struct {
+	Field string
+	Func  string
+
+	values func(Metric) []float64
+	reduce func([]float64) float64
+}
+
+// NewAggregation supports the fields cpu, memory, network_in and network_out
+// and the functions avg, min, max, sum, count, last and pNN (e.g. p95, p99.9).
+// Any other field is looked up by name among the generic samples, and every
+// labelled series with that name contributes a value.
+func NewAggregation(field, fn string) (*Aggregation, error) {
+	if field == "" {
+		return nil, fmt.Errorf("field cannot be empty")
+	}
+	values := func(m Metric) []float64 {
+		var v []float64
+		for _, s := range m.Samples {
+			if s.Name == field {
+				v = append(v, s.Value)
+			}
+		}
+		return v
+	}
+	if value, ok := metricFields[field]; ok {
+		values = func(m Metric) []float64 { return []float64{value(m)} }
+	}
+	reduce, err := reduceFunc(fn)
+	if err != nil {
+		return nil, err
+	}
+	return &Aggregation{
+		Field:  field,
+		Func:   fn,
+		values: values,
+		reduce: reduce,
+	}, nil
+}
+
+// Apply returns the statistic over metrics, or false if there are none.
+func (a *Aggregation) Apply(metrics []Metric) (float64, bool) {
+	values := make([]float64, 0, len(metrics))
+	for _, m := range metrics {
+		values = append(values, a.values(m)...)
+	}
+	if len(values) == 0 {
+		return 0, false
+	}
+	return a.reduce(values), true
+}
+
+func reduceFunc(fn string) (func([]float64) float64, error) {
+	switch fn {
+	case "avg":
+		return func(v []float64) float64 { return sum(v) / float64(len(v)) }, nil
+	case "min":
+		return slices.Min[[]float64], nil
+	case "max":
+		return slices.Max[[]float64], nil
+	case "sum":
+		return sum, nil
+	case "count":
+		return func(v []float64) float64 { return float64(len(v)) }, nil
+	case "last":
+		return func(v []float64) float64 { return v[len(v)-1] }, nil
+	}
+
+	if p, ok := strings.CutPrefix(fn, "p"); ok {
+		q, err := strconv.ParseFloat(p, 64)
+		if err != nil || math.IsNaN(q) || q < 0 || q > 100 {
+			return nil, fmt.Errorf("invalid percentile: %s", fn)
+		}
+		return func(v []float64) float64 { return percentile(v, q/100) }, nil
+	}
+	return nil, fmt.Errorf("unknown aggregation function: %s", fn)
+}
+
+func sum(values []float64) float64 {
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// percentile returns the q-quantile (0..1) of values, interpolating linearly
+// between the closest ranks.
+func percentile(values []float64, q float64) float64 {
+	sorted := append([]float64(nil), values...)
+	slices.Sort(sorted)
+
+	rank := q * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	if lo == hi {
+		return sorted[lo]
+	}
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
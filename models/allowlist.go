@@ -0,0 +1,95 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/netip"
+	"os"
+	"sync"
+)
+
+// MemoryStore is the in-memory backend with a durable allowlist. Samples are
+// lost on restart, but every change to the allowed names and their CIDRs is
+// written through to a JSON file and loaded again on startup.
+type MemoryStore struct {
+	*MetricsStore
+
+	// mu serialises changes so that the file is written in the same order
+	// as the store is changed.
+	mu   sync.Mutex
+	path string
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+type allowlistFile struct {
+	AllowedNames []string                  `json:"allowed_names"`
+	CIDRs        map[string][]netip.Prefix `json:"cidrs,omitempty"`
+}
+
+// OpenMemoryStore loads the allowlist kept in path. A missing file means an
+// empty allowlist.
+func OpenMemoryStore(path string, retention Retention) (*MemoryStore, error) {
+	ms := &MemoryStore{MetricsStore: NewMetricsStore(retention), path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return ms, nil
+	}
+
+	var f allowlistFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	for _, name := range f.AllowedNames {
+		ms.MetricsStore.AddAllowedName(name)
+	}
+	for name, cidrs := range f.CIDRs {
+		ms.MetricsStore.SetAllowedCIDRs(name, cidrs)
+	}
+	return ms, nil
+}
+
+func (ms *MemoryStore) AddAllowedName(name string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.MetricsStore.IsAllowed(name) {
+		return
+	}
+	ms.MetricsStore.AddAllowedName(name)
+	ms.save()
+}
+
+func (ms *MemoryStore) RemoveName(name string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if !ms.MetricsStore.IsAllowed(name) {
+		return
+	}
+	ms.MetricsStore.RemoveName(name)
+	ms.save()
+}
+
+func (ms *MemoryStore) SetAllowedCIDRs(name string, cidrs []netip.Prefix) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if !ms.MetricsStore.IsAllowed(name) {
+		return
+	}
+	ms.MetricsStore.SetAllowedCIDRs(name, cidrs)
+	ms.save()
+}
+
+// save writes the allowlist to disk. Callers must hold ms.mu.
+func (ms *MemoryStore) save() {
+	f := allowlistFile{
+		AllowedNames: ms.GetAllowedNames(),
+		CIDRs:        ms.allCIDRs(),
+	}
+	if err := writeFileAtomic(ms.path, f); err != nil {
+		log.Printf("memory store: save allowlist failed: %v", err)
+	}
+}
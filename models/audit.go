@@ -0,0 +1,112 @@
+package models
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// AuditEntry records one administrative request. Payload is the request
+// body, kept as JSON when it is valid JSON.
+type AuditEntry struct {
+	Time    int64           `json:"time"`
+	Key     string          `json:"key"`
+	Role    Role            `json:"role,omitempty"`
+	IP      string          `json:"ip"`
+	Method  string          `json:"method"`
+	Route   string          `json:"route"`
+	Path    string          `json:"path"`
+	Status  int             `json:"status"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// AuditFilter selects audit entries. Zero fields match everything.
+type AuditFilter struct {
+	From  int64
+	To    int64
+	Key   string
+	Route string
+	Limit int
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+	return (f.From == 0 || e.Time >= f.From) &&
+		(f.To == 0 || e.Time <= f.To) &&
+		(f.Key == "" || e.Key == f.Key) &&
+		(f.Route == "" || e.Route == f.Route)
+}
+
+// AuditLog is an append-only JSON-lines file. Entries are synced one by one
+// since administrative changes are rare and must survive a crash.
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{path: path, f: f}, nil
+}
+
+func (a *AuditLog) Append(e AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+// Query returns the entries matching filter in the order they were
+// written. With a limit only the most recent entries are returned.
+func (a *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	f, err := os.Open(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]AuditEntry, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Skip a line torn by a crash rather than hiding everything after it.
+			continue
+		}
+		if !filter.match(e) {
+			continue
+		}
+		entries = append(entries, e)
+		if filter.Limit > 0 && len(entries) > 2*filter.Limit {
+			entries = append(entries[:0], entries[len(entries)-filter.Limit:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries, nil
+}
+
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
@@ -0,0 +1,304 @@
+package models
+
+import (
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// chunkSize is the number of samples sealed into one compressed chunk. At
+// the default 10s push interval a chunk covers 20 minutes.
+const chunkSize = 120
+
+// chunk is a sealed, compressed block of consecutive samples of one series.
+// Timestamps are delta-of-delta encoded and every float field is XOR encoded
+// against its previous value, as described in Facebook's Gorilla paper. The
+// name is stored once, the IP once per run, and the generic samples once as
+// a layout whose values are encoded like the fixed fields. Samples that
+// carry histograms or summaries do not fit that scheme and are kept as is.
+type chunk struct {
+	minT  int64
+	maxT  int64
+	count int
+
+	name   string
+	ips    []ipRun
+	layout []Sample
+	data   []byte
+
+	raw []Metric
+}
+
+type ipRun struct {
+	start int
+	ip    string
+}
+
+// sameLayout reports whether two samples carry the same generic samples in
+// the same order, so that they can share one chunk layout.
+func sameLayout(a, b Metric) bool {
+	if len(a.Samples) != len(b.Samples) {
+		return false
+	}
+	for i, s := range b.Samples {
+		f := a.Samples[i]
+		if s.Name != f.Name || s.Type != f.Type || LabelsKey(s.Labels) != LabelsKey(f.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func compressible(first, metric Metric) bool {
+	if !sameLayout(first, metric) {
+		return false
+	}
+	for _, s := range metric.Samples {
+		if s.Histogram != nil || s.Summary != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newChunk(metrics []Metric) *chunk {
+	c := &chunk{
+		minT:  metrics[0].Timestamp,
+		maxT:  metrics[0].Timestamp,
+		count: len(metrics),
+	}
+	for _, m := range metrics {
+		c.minT = min(c.minT, m.Timestamp)
+		c.maxT = max(c.maxT, m.Timestamp)
+	}
+	for _, m := range metrics {
+		if !compressible(metrics[0], m) {
+			c.raw = metrics
+			return c
+		}
+	}
+
+	c.name = metrics[0].Name
+	c.layout = make([]Sample, len(metrics[0].Samples))
+	for i, s := range metrics[0].Samples {
+		c.layout[i] = Sample{Name: s.Name, Type: s.Type, Labels: s.Labels}
+	}
+
+	w := &bitWriter{}
+	var prevT, prevDelta int64
+	floats := make([]xorState, 4+len(c.layout))
+	for i, m := range metrics {
+		if n := len(c.ips); n == 0 || c.ips[n-1].ip != m.IP {
+			c.ips = append(c.ips, ipRun{start: i, ip: m.IP})
+		}
+
+		if i == 0 {
+			w.writeBits(uint64(m.Timestamp), 64)
+		} else {
+			delta := m.Timestamp - prevT
+			writeDoD(w, delta-prevDelta)
+			prevDelta = delta
+		}
+		prevT = m.Timestamp
+
+		floats[0].write(w, m.CPUUsage)
+		floats[1].write(w, m.MemoryUsage)
+		floats[2].write(w, m.NetworkIn)
+		floats[3].write(w, m.NetworkOut)
+		for j, s := range m.Samples {
+			floats[4+j].write(w, s.Value)
+		}
+	}
+	c.data = slices.Clip(w.buf)
+	return c
+}
+
+// decode appends the samples of the chunk to dst. Labels maps of generic
+// samples are shared between the decoded samples and must not be modified.
+func (c *chunk) decode(dst []Metric) []Metric {
+	if c.raw != nil {
+		return append(dst, c.raw...)
+	}
+
+	r := &bitReader{buf: c.data}
+	var prevT, prevDelta int64
+	floats := make([]xorState, 4+len(c.layout))
+	run := 0
+	for i := 0; i < c.count; i++ {
+		if run+1 < len(c.ips) && c.ips[run+1].start == i {
+			run++
+		}
+
+		m := Metric{Name: c.name, IP: c.ips[run].ip}
+		if i == 0 {
+			m.Timestamp = int64(r.readBits(64))
+		} else {
+			prevDelta += readDoD(r)
+			m.Timestamp = prevT + prevDelta
+		}
+		prevT = m.Timestamp
+
+		m.CPUUsage = floats[0].read(r)
+		m.MemoryUsage = floats[1].read(r)
+		m.NetworkIn = floats[2].read(r)
+		m.NetworkOut = floats[3].read(r)
+		if len(c.layout) > 0 {
+			m.Samples = make([]Sample, len(c.layout))
+			for j, s := range c.layout {
+				s.Value = floats[4+j].read(r)
+				m.Samples[j] = s
+			}
+		}
+		dst = append(dst, m)
+	}
+	return dst
+}
+
+// writeDoD writes a delta-of-delta using the Gorilla variable-width buckets.
+func writeDoD(w *bitWriter, dod int64) {
+	switch {
+	case dod == 0:
+		w.writeBits(0b0, 1)
+	case fitsSigned(dod, 7):
+		w.writeBits(0b10, 2)
+		w.writeBits(uint64(dod), 7)
+	case fitsSigned(dod, 9):
+		w.writeBits(0b110, 3)
+		w.writeBits(uint64(dod), 9)
+	case fitsSigned(dod, 12):
+		w.writeBits(0b1110, 4)
+		w.writeBits(uint64(dod), 12)
+	default:
+		w.writeBits(0b1111, 4)
+		w.writeBits(uint64(dod), 64)
+	}
+}
+
+func readDoD(r *bitReader) int64 {
+	width := 64
+	switch {
+	case r.readBits(1) == 0:
+		return 0
+	case r.readBits(1) == 0:
+		width = 7
+	case r.readBits(1) == 0:
+		width = 9
+	case r.readBits(1) == 0:
+		width = 12
+	}
+	return signExtend(r.readBits(width), width)
+}
+
+func fitsSigned(v int64, width int) bool {
+	limit := int64(1) << (width - 1)
+	return v >= -limit && v < limit
+}
+
+func signExtend(v uint64, width int) int64 {
+	shift := 64 - width
+	return int64(v<<shift) >> shift
+}
+
+// xorState is the encoder/decoder state of one XOR-compressed float stream.
+type xorState struct {
+	started  bool
+	prev     uint64
+	leading  int
+	trailing int
+}
+
+func (x *xorState) write(w *bitWriter, v float64) {
+	cur := math.Float64bits(v)
+	if !x.started {
+		x.started = true
+		x.prev = cur
+		x.leading = -1
+		w.writeBits(cur, 64)
+		return
+	}
+
+	xor := cur ^ x.prev
+	x.prev = cur
+	if xor == 0 {
+		w.writeBits(0b0, 1)
+		return
+	}
+	w.writeBits(0b1, 1)
+
+	leading := min(bits.LeadingZeros64(xor), 31)
+	trailing := bits.TrailingZeros64(xor)
+	if x.leading >= 0 && leading >= x.leading && trailing >= x.trailing {
+		// The meaningful bits fit into the previous window.
+		w.writeBits(0b0, 1)
+		w.writeBits(xor>>x.trailing, 64-x.leading-x.trailing)
+		return
+	}
+
+	x.leading, x.trailing = leading, trailing
+	sigbits := 64 - leading - trailing
+	w.writeBits(0b1, 1)
+	w.writeBits(uint64(leading), 5)
+	// 64 significant bits do not fit into 6 bits and are written as 0.
+	w.writeBits(uint64(sigbits&63), 6)
+	w.writeBits(xor>>trailing, sigbits)
+}
+
+func (x *xorState) read(r *bitReader) float64 {
+	if !x.started {
+		x.started = true
+		x.prev = r.readBits(64)
+		return math.Float64frombits(x.prev)
+	}
+
+	if r.readBits(1) == 0 {
+		return math.Float64frombits(x.prev)
+	}
+	if r.readBits(1) == 1 {
+		x.leading = int(r.readBits(5))
+		sigbits := int(r.readBits(6))
+		if sigbits == 0 {
+			sigbits = 64
+		}
+		x.trailing = 64 - x.leading - sigbits
+	}
+	sigbits := 64 - x.leading - x.trailing
+	x.prev ^= r.readBits(sigbits) << x.trailing
+	return math.Float64frombits(x.prev)
+}
+
+type bitWriter struct {
+	buf  []byte
+	free int // unused bits in the last byte
+}
+
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for n > 0 {
+		if w.free == 0 {
+			w.buf = append(w.buf, 0)
+			w.free = 8
+		}
+		take := min(n, w.free)
+		part := byte((v >> (n - take)) & (1<<take - 1))
+		w.buf[len(w.buf)-1] |= part << (w.free - take)
+		w.free -= take
+		n -= take
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos int // bit offset
+}
+
+func (r *bitReader) readBits(n int) uint64 {
+	var v uint64
+	for n > 0 {
+		b := r.buf[r.pos/8]
+		avail := 8 - r.pos%8
+		take := min(n, avail)
+		v = v<<take | uint64(b>>(avail-take))&(1<<take-1)
+		r.pos += take
+		n -= take
+	}
+	return v
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func checkRoundTrip(t *testing.T, metrics []Metric) {
+	t.Helper()
+	got := newChunk(metrics).decode(nil)
+	if len(got) != len(metrics) {
+		t.Fatalf("decoded %d metrics, want %d", len(got), len(metrics))
+	}
+	for i := range metrics {
+		// %v tells NaN, -0 and the infinities apart, which == does not.
+		if g, w := fmt.Sprintf("%+v", got[i]), fmt.Sprintf("%+v", metrics[i]); g != w {
+			t.Errorf("metric %d:\n got %s\nwant %s", i, g, w)
+		}
+	}
+}
+
+func TestChunkRoundTripTimestamps(t *testing.T) {
+	for _, ts := range [][]int64{
+		{100},
+		{100, 110, 120, 130},
+		// Shrinking and negative deltas.
+		{100, 110, 115, 116, 90, -5, -5},
+		// Gaps that need every delta-of-delta width.
+		{0, 63, 200, 2000, 1 << 20, 1 << 33, 1<<33 + 1, 1<<62 - 1, 10},
+		{math.MinInt64 / 2, 0, math.MaxInt64 / 2},
+	} {
+		metrics := make([]Metric, len(ts))
+		for i, t := range ts {
+			metrics[i] = Metric{Name: "web-01", IP: "10.0.0.1", Timestamp: t, CPUUsage: float64(i)}
+		}
+		checkRoundTrip(t, metrics)
+	}
+}
+
+func TestChunkRoundTripFloats(t *testing.T) {
+	values := []float64{
+		0, 0, 1.5, 1.5, -1.5, math.Copysign(0, -1), math.NaN(), math.Inf(1), math.Inf(-1),
+		math.MaxFloat64, math.SmallestNonzeroFloat64, 1e-300, 123456.789, 0.1, 0.2,
+	}
+	metrics := make([]Metric, len(values))
+	for i, v := range values {
+		metrics[i] = Metric{
+			Name:        "web-01",
+			IP:          "10.0.0.1",
+			Timestamp:   int64(i) * 10,
+			CPUUsage:    v,
+			MemoryUsage: -v,
+			NetworkIn:   values[len(values)-1-i],
+			NetworkOut:  float64(i),
+			Samples: []Sample{
+				{Name: "load1", Value: v, Type: SampleGauge},
+				{Name: "disk_used", Value: float64(i) * 1e9, Type: SampleGauge, Labels: map[string]string{"mount": "/"}},
+			},
+		}
+	}
+	// The address changes and changes back.
+	for i := 5; i < 9; i++ {
+		metrics[i].IP = "10.0.0.2"
+	}
+	checkRoundTrip(t, metrics)
+}
+
+func TestChunkKeepsHistogramsRaw(t *testing.T) {
+	metrics := []Metric{
+		{Name: "web-01", Timestamp: 1, Samples: []Sample{{Name: "latency", Type: SampleHistogram, Histogram: &Histogram{Count: 1}}}},
+		{Name: "web-01", Timestamp: 2, Samples: []Sample{{Name: "latency", Type: SampleHistogram, Histogram: &Histogram{Count: 2}}}},
+	}
+	c := newChunk(metrics)
+	if c.raw == nil {
+		t.Error("histograms were compressed")
+	}
+	checkRoundTrip(t, metrics)
+}
+
+// A change of layout seals the head, so a series holds chunks of several
+// layouts.
+func TestSeriesLayoutChanges(t *testing.T) {
+	var s series
+	var want []Metric
+	layouts := [][]Sample{
+		nil,
+		{{Name: "load1", Type: SampleGauge}},
+		{{Name: "load1", Type: SampleGauge}, {Name: "disk_used", Type: SampleGauge, Labels: map[string]string{"mount": "/"}}},
+		{{Name: "disk_used", Type: SampleGauge, Labels: map[string]string{"mount": "/data"}}},
+		nil,
+	}
+	ts := int64(0)
+	for _, layout := range layouts {
+		for range chunkSize + 3 {
+			ts += 10
+			m := Metric{Name: "web-01", IP: "10.0.0.1", Timestamp: ts, CPUUsage: float64(ts % 7)}
+			for _, l := range layout {
+				l.Value = float64(ts)
+				m.Samples = append(m.Samples, l)
+			}
+			s.append(m)
+			want = append(want, m)
+		}
+	}
+
+	got := s.all()
+	if g, w := fmt.Sprintf("%+v", got), fmt.Sprintf("%+v", want); g != w {
+		t.Errorf("series does not round-trip across layout changes")
+	}
+	if got := s.between(want[100].Timestamp, want[200].Timestamp); len(got) != 101 {
+		t.Errorf("between returned %d metrics, want 101", len(got))
+	}
+}
+
+func TestAddMetricDropsOutOfOrder(t *testing.T) {
+	ms := NewMetricsStore(DefaultRetention())
+	ms.AddAllowedName("web-01")
+	for _, ts := range []int64{10, 20, 20, 15, 30, 5} {
+		ms.AddMetric("web-01", Metric{Name: "web-01", Timestamp: ts})
+	}
+	if got := timestamps(ms.GetMetrics("web-01")); fmt.Sprint(got) != "[10 20 30]" {
+		t.Errorf("timestamps = %v, want [10 20 30]", got)
+	}
+}
+
+func TestDiskStoreDropsOutOfOrder(t *testing.T) {
+	dir := t.TempDir()
+	ds := openTestDiskStore(t, dir)
+	ds.AddAllowedName("web-01")
+	for _, ts := range []int64{10, 20, 15, 30} {
+		ds.AddMetric("web-01", Metric{Name: "web-01", Timestamp: ts})
+	}
+	ds.Close()
+
+	ds = openTestDiskStore(t, dir)
+	defer ds.Close()
+	if got := timestamps(ds.GetMetrics("web-01")); fmt.Sprint(got) != "[10 20 30]" {
+		t.Errorf("timestamps after reopen = %v, want [10 20 30]", got)
+	}
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseCIDRs parses CIDRs such as "10.0.0.0/24". A plain address is taken
+// as a single-host prefix.
+func ParseCIDRs(list []string) ([]netip.Prefix, error) {
+	cidrs := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", s, err)
+			}
+			cidrs = append(cidrs, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", s, err)
+		}
+		cidrs = append(cidrs, p.Masked())
+	}
+	return cidrs, nil
+}
+
+// CIDRsContain reports whether ip falls into one of cidrs. An empty list
+// allows every address.
+func CIDRsContain(cidrs []netip.Prefix, ip string) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range cidrs {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
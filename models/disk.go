@@ -0,0 +1,483 @@
+package models
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	walFile      = "wal.log"
+	walOldFile   = "wal.old"
+	snapshotFile = "snapshot.json"
+)
+
+const (
+	opMetric = "metric"
+	opAllow  = "allow"
+	opRemove = "remove"
+	opCIDRs  = "cidrs"
+)
+
+type walEntry struct {
+	Seq    uint64         `json:"seq,omitempty"`
+	Op     string         `json:"op"`
+	Name   string         `json:"name"`
+	Metric *Metric        `json:"metric,omitempty"`
+	CIDRs  []netip.Prefix `json:"cidrs,omitempty"`
+}
+
+type snapshot struct {
+	Seq          uint64                    `json:"seq"`
+	AllowedNames []string                  `json:"allowed_names"`
+	Metrics      map[string][]Metric       `json:"metrics"`
+	Rollups      map[string][][]Rollup     `json:"rollups"`
+	CIDRs        map[string][]netip.Prefix `json:"cidrs,omitempty"`
+	// Seqs holds the sequence number of the last sample of every name. The
+	// state is copied after Seq is taken, so it may already contain later
+	// log entries; replay skips the samples and removals it covers.
+	Seqs map[string]uint64 `json:"seqs,omitempty"`
+}
+
+// DiskStore keeps the in-memory MetricsStore as the source of truth for
+// reads and makes it durable by appending every mutation to a write-ahead
+// log. The log is flushed every syncInterval and folded into a snapshot
+// every snapshotInterval, so a crash loses at most one sync interval.
+type DiskStore struct {
+	*MetricsStore
+
+	dir string
+
+	mu  sync.Mutex
+	seq uint64
+	wal *os.File
+	buf *bufio.Writer
+
+	snapMu sync.Mutex
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+var _ Store = (*DiskStore)(nil)
+
+func OpenDiskStore(dir string, retention Retention, syncInterval, snapshotInterval time.Duration) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	ds := &DiskStore{
+		MetricsStore: NewMetricsStore(retention),
+		dir:          dir,
+		done:         make(chan struct{}),
+	}
+	if err := ds.replay(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	ds.wal = wal
+	ds.buf = bufio.NewWriter(wal)
+
+	ds.wg.Add(1)
+	go ds.loop(syncInterval, snapshotInterval)
+	return ds, nil
+}
+
+func (ds *DiskStore) AddAllowedName(name string) {
+	if ds.IsAllowed(name) {
+		return
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.MetricsStore.AddAllowedName(name)
+	ds.append(walEntry{Op: opAllow, Name: name})
+}
+
+func (ds *DiskStore) AddMetric(name string, metric Metric) {
+	if !ds.IsAllowed(name) {
+		return
+	}
+	// Encode before taking the lock; only the sequence number is added
+	// under it.
+	data, err := json.Marshal(walEntry{Op: opMetric, Name: name, Metric: &metric})
+	if err != nil {
+		log.Printf("disk store: encode wal entry failed: %v", err)
+		return
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	// Out-of-order metrics are dropped by the store; keep them out of the
+	// log as well. ds.mu orders this check with the append below.
+	if !ds.MetricsStore.accepts(name, metric.Timestamp) {
+		log.Printf("disk store: dropping metric of %s at %d: not newer than the last one", name, metric.Timestamp)
+		return
+	}
+	seq := ds.write(data)
+	ds.MetricsStore.addMetric(name, metric, seq)
+}
+
+func (ds *DiskStore) RemoveName(name string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.MetricsStore.RemoveName(name)
+	ds.append(walEntry{Op: opRemove, Name: name})
+}
+
+func (ds *DiskStore) SetAllowedCIDRs(name string, cidrs []netip.Prefix) {
+	if !ds.IsAllowed(name) {
+		return
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.MetricsStore.SetAllowedCIDRs(name, cidrs)
+	ds.append(walEntry{Op: opCIDRs, Name: name, CIDRs: cidrs})
+}
+
+func (ds *DiskStore) Cleanup() {
+	ds.MetricsStore.Cleanup()
+	if err := ds.Snapshot(); err != nil {
+		log.Printf("disk store: snapshot after cleanup failed: %v", err)
+	}
+}
+
+// Close stops the background loop, writes a final snapshot and closes the
+// log. Only the first call does anything; later calls return its result.
+func (ds *DiskStore) Close() error {
+	ds.closeOnce.Do(func() {
+		ds.closeErr = ds.close()
+	})
+	return ds.closeErr
+}
+
+func (ds *DiskStore) close() error {
+	close(ds.done)
+	ds.wg.Wait()
+
+	snapErr := ds.Snapshot()
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.buf.Flush(); err != nil {
+		return err
+	}
+	if err := ds.wal.Sync(); err != nil {
+		return err
+	}
+	if err := ds.wal.Close(); err != nil {
+		return err
+	}
+	return snapErr
+}
+
+// Snapshot writes the full store state to disk and drops the log entries it
+// covers. Ingest is only blocked while the log is rotated; the state is
+// copied and written without holding the log lock.
+func (ds *DiskStore) Snapshot() error {
+	ds.snapMu.Lock()
+	defer ds.snapMu.Unlock()
+
+	ds.mu.Lock()
+	seq := ds.seq
+	err := ds.rotate()
+	ds.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	metrics, seqs := ds.copyMetrics()
+	snap := snapshot{
+		Seq:          seq,
+		AllowedNames: ds.GetAllowedNames(),
+		Metrics:      metrics,
+		Rollups:      ds.getAllRollups(),
+		CIDRs:        ds.allCIDRs(),
+		Seqs:         seqs,
+	}
+	if err := writeFileAtomic(filepath.Join(ds.dir, snapshotFile), snap); err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(ds.dir, walOldFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// rotate moves the current log aside so that a new one can be started while
+// the snapshot is being written. Callers must hold ds.mu.
+func (ds *DiskStore) rotate() error {
+	if ds.wal == nil {
+		return nil
+	}
+	if err := ds.sync(); err != nil {
+		return err
+	}
+	if err := ds.wal.Close(); err != nil {
+		ds.reopen()
+		return err
+	}
+	cur := filepath.Join(ds.dir, walFile)
+	old := filepath.Join(ds.dir, walOldFile)
+	// An old log left behind by a failed snapshot still holds entries that
+	// are not covered by any snapshot, so keep them ahead of the current ones.
+	if err := appendFile(old, cur); err != nil {
+		ds.reopen()
+		return err
+	}
+	wal, err := os.OpenFile(cur, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		ds.reopen()
+		return err
+	}
+	ds.wal = wal
+	ds.buf.Reset(wal)
+	return nil
+}
+
+// reopen opens the current log for appending after a failed rotation, so
+// that later entries are not written to a closed file. Entries that were
+// partly copied to the old log are skipped on replay by their sequence
+// numbers. Callers must hold ds.mu.
+func (ds *DiskStore) reopen() {
+	wal, err := os.OpenFile(filepath.Join(ds.dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Printf("disk store: reopen wal failed: %v", err)
+		return
+	}
+	ds.wal = wal
+	ds.buf.Reset(wal)
+}
+
+// append must be called with ds.mu held.
+func (ds *DiskStore) append(e walEntry) {
+	e.Seq = 0
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("disk store: encode wal entry failed: %v", err)
+		return
+	}
+	ds.write(data)
+}
+
+// write logs an entry encoded without its sequence number under the next
+// sequence number, which it returns. It must be called with ds.mu held.
+func (ds *DiskStore) write(data []byte) uint64 {
+	ds.seq++
+	line := make([]byte, 0, len(data)+24)
+	line = append(line, `{"seq":`...)
+	line = strconv.AppendUint(line, ds.seq, 10)
+	line = append(line, ',')
+	line = append(line, data[1:]...)
+	line = append(line, '\n')
+	if _, err := ds.buf.Write(line); err != nil {
+		log.Printf("disk store: write wal entry failed: %v", err)
+	}
+	return ds.seq
+}
+
+// sync must be called with ds.mu held.
+func (ds *DiskStore) sync() error {
+	if err := ds.buf.Flush(); err != nil {
+		return err
+	}
+	return ds.wal.Sync()
+}
+
+func (ds *DiskStore) loop(syncInterval, snapshotInterval time.Duration) {
+	defer ds.wg.Done()
+
+	syncTicker := time.NewTicker(syncInterval)
+	defer syncTicker.Stop()
+	snapTicker := time.NewTicker(snapshotInterval)
+	defer snapTicker.Stop()
+
+	for {
+		select {
+		case <-ds.done:
+			return
+		case <-syncTicker.C:
+			ds.mu.Lock()
+			err := ds.sync()
+			ds.mu.Unlock()
+			if err != nil {
+				log.Printf("disk store: sync wal failed: %v", err)
+			}
+		case <-snapTicker.C:
+			if err := ds.Snapshot(); err != nil {
+				log.Printf("disk store: snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+func (ds *DiskStore) replay() error {
+	var snap snapshot
+	f, err := os.Open(filepath.Join(ds.dir, snapshotFile))
+	switch {
+	case err == nil:
+		err = json.NewDecoder(f).Decode(&snap)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("decode snapshot: %w", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	for _, name := range snap.AllowedNames {
+		ds.MetricsStore.AddAllowedName(name)
+	}
+	for name, metrics := range snap.Metrics {
+		ds.restore(name, metrics, nil)
+	}
+	for name, rollups := range snap.Rollups {
+		ds.restore(name, nil, rollups)
+	}
+	for name, cidrs := range snap.CIDRs {
+		ds.MetricsStore.SetAllowedCIDRs(name, cidrs)
+	}
+	ds.seq = snap.Seq
+
+	for _, name := range []string{walOldFile, walFile} {
+		if err := ds.replayWAL(filepath.Join(ds.dir, name), &snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *DiskStore) replayWAL(path string, snap *snapshot) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e walEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A torn write at the tail of the log is expected after a crash.
+			log.Printf("disk store: stop replaying %s at corrupt entry: %v", path, err)
+			break
+		}
+		// Entries can be in both logs after a failed rotation.
+		if e.Seq <= ds.seq {
+			continue
+		}
+		// The snapshot may have been copied after this entry was applied.
+		// Other entries set state and can be applied twice.
+		last := snap.Seqs[e.Name]
+		if (e.Op == opMetric && e.Seq <= last) || (e.Op == opRemove && e.Seq < last) {
+			ds.seq = e.Seq
+			continue
+		}
+		ds.apply(e)
+		ds.seq = e.Seq
+	}
+	return scanner.Err()
+}
+
+func (ds *DiskStore) apply(e walEntry) {
+	switch e.Op {
+	case opMetric:
+		if e.Metric != nil {
+			ds.MetricsStore.addMetric(e.Name, *e.Metric, e.Seq)
+		}
+	case opAllow:
+		ds.MetricsStore.AddAllowedName(e.Name)
+	case opRemove:
+		ds.MetricsStore.RemoveName(e.Name)
+	case opCIDRs:
+		ds.MetricsStore.SetAllowedCIDRs(e.Name, e.CIDRs)
+	}
+}
+
+func writeFileAtomic(path string, v any) error {
+	return WriteFileAtomic(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(v)
+	})
+}
+
+// WriteFileAtomic replaces path with what write produces. The data is
+// written to a temporary file and synced before it is renamed over path, so
+// a crash leaves either the old or the new contents.
+func WriteFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// appendFile appends the contents of src to dst and removes src.
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
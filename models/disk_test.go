@@ -0,0 +1,183 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func openTestDiskStore(tb testing.TB, dir string) *DiskStore {
+	tb.Helper()
+	ds, err := OpenDiskStore(dir, DefaultRetention(), time.Hour, time.Hour)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ds
+}
+
+// BenchmarkAddMetricParallel pushes to 64 names from parallel goroutines.
+// Run it with -cpu 1,2,4,8 to see how ingest scales.
+func BenchmarkAddMetricParallel(b *testing.B) {
+	for _, snapshots := range []bool{false, true} {
+		b.Run(fmt.Sprintf("snapshots=%v", snapshots), func(b *testing.B) {
+			ds := openTestDiskStore(b, b.TempDir())
+			defer ds.Close()
+			const names = 64
+			for i := range names {
+				ds.AddAllowedName(fmt.Sprintf("web-%02d", i))
+			}
+
+			done := make(chan struct{})
+			if snapshots {
+				go func() {
+					for {
+						select {
+						case <-done:
+							return
+						default:
+							ds.Snapshot()
+						}
+					}
+				}()
+			}
+
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				name := fmt.Sprintf("web-%02d", next.Add(1)%names)
+				m := Metric{Name: name, IP: "10.0.0.1", CPUUsage: 12.5, MemoryUsage: 40, NetworkIn: 1e6, NetworkOut: 2e6}
+				for pb.Next() {
+					m.Timestamp++
+					ds.AddMetric(name, m)
+				}
+			})
+			b.StopTimer()
+			close(done)
+		})
+	}
+}
+
+func TestDiskStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	ds := openTestDiskStore(t, dir)
+	ds.AddAllowedName("web-01")
+	for ts := int64(1); ts <= 3; ts++ {
+		ds.AddMetric("web-01", Metric{Name: "web-01", Timestamp: ts})
+	}
+	if err := ds.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	ds.AddMetric("web-01", Metric{Name: "web-01", Timestamp: 4})
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ds = openTestDiskStore(t, dir)
+	defer ds.Close()
+	if got := timestamps(ds.GetMetrics("web-01")); fmt.Sprint(got) != "[1 2 3 4]" {
+		t.Errorf("timestamps after reopen = %v, want [1 2 3 4]", got)
+	}
+}
+
+// A snapshot is copied after its sequence number is taken, so it can hold
+// entries that are also in the log after it.
+func TestDiskStoreReplaySkipsEntriesInSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	snap := snapshot{
+		Seq:          2,
+		AllowedNames: []string{"web-01", "web-02"},
+		Metrics: map[string][]Metric{
+			"web-01": {{Name: "web-01", Timestamp: 1}, {Name: "web-01", Timestamp: 3}},
+			"web-02": {{Name: "web-02", Timestamp: 5}},
+		},
+		Seqs: map[string]uint64{"web-01": 3, "web-02": 6},
+	}
+	writeJSON(t, filepath.Join(dir, snapshotFile), snap)
+	wal := []walEntry{
+		{Seq: 3, Op: opMetric, Name: "web-01", Metric: &Metric{Name: "web-01", Timestamp: 3}},
+		{Seq: 4, Op: opRemove, Name: "web-02"},
+		{Seq: 5, Op: opAllow, Name: "web-02"},
+		{Seq: 6, Op: opMetric, Name: "web-02", Metric: &Metric{Name: "web-02", Timestamp: 5}},
+		{Seq: 7, Op: opMetric, Name: "web-01", Metric: &Metric{Name: "web-01", Timestamp: 4}},
+	}
+	f, err := os.Create(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range wal {
+		enc.Encode(e)
+	}
+	f.Close()
+
+	ds := openTestDiskStore(t, dir)
+	defer ds.Close()
+	if got := timestamps(ds.GetMetrics("web-01")); fmt.Sprint(got) != "[1 3 4]" {
+		t.Errorf("web-01 timestamps = %v, want [1 3 4]", got)
+	}
+	if got := timestamps(ds.GetMetrics("web-02")); fmt.Sprint(got) != "[5]" {
+		t.Errorf("web-02 timestamps = %v, want [5]", got)
+	}
+
+	ds.AddMetric("web-01", Metric{Name: "web-01", Timestamp: 5})
+	if ds.seq != 8 {
+		t.Errorf("seq = %d, want 8", ds.seq)
+	}
+}
+
+func timestamps(metrics []Metric) []int64 {
+	ts := make([]int64, len(metrics))
+	for i, m := range metrics {
+		ts[i] = m.Timestamp
+	}
+	return ts
+}
+
+func writeJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	if err := writeFileAtomic(path, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskStoreCloseTwice(t *testing.T) {
+	ds := openTestDiskStore(t, t.TempDir())
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskStoreFailedRotationKeepsLogging(t *testing.T) {
+	dir := t.TempDir()
+	ds := openTestDiskStore(t, dir)
+	ds.AddAllowedName("web-01")
+	ds.AddMetric("web-01", Metric{Name: "web-01", Timestamp: 1})
+
+	// The current log cannot be appended to a directory, so rotation fails
+	// after the log was closed.
+	old := filepath.Join(dir, walOldFile)
+	if err := os.Mkdir(old, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Snapshot(); err == nil {
+		t.Fatal("Snapshot succeeded with a broken old log")
+	}
+	ds.AddMetric("web-01", Metric{Name: "web-01", Timestamp: 2})
+	ds.Close()
+
+	if err := os.Remove(old); err != nil {
+		t.Fatal(err)
+	}
+	ds = openTestDiskStore(t, dir)
+	defer ds.Close()
+	if got := timestamps(ds.GetMetrics("web-01")); fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("timestamps after reopen = %v, want [1 2]", got)
+	}
+}
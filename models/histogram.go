@@ -0,0 +1,187 @@
+package models
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Bucket is a cumulative histogram bucket. The +Inf bucket is implicit and
+// equal to the histogram count.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+type Histogram struct {
+	Buckets []Bucket
+	Sum     float64
+	Count   uint64
+}
+
+type Quantile struct {
+	Quantile float64
+	Value    float64
+}
+
+// Summary holds quantiles precomputed by the client. Unlike histograms they
+// cannot be merged across hosts.
+type Summary struct {
+	Quantiles []Quantile
+	Sum       float64
+	Count     uint64
+}
+
+// countAt returns the cumulative count of observations <= bound.
+func (h *Histogram) countAt(bound float64) uint64 {
+	i := sort.Search(len(h.Buckets), func(i int) bool { return h.Buckets[i].UpperBound > bound })
+	if i == 0 {
+		return 0
+	}
+	return h.Buckets[i-1].Count
+}
+
+// MergeHistograms adds histograms together. Bucket bounds are unioned; a
+// histogram without a given bound contributes its count at the nearest lower
+// bound, which keeps the merged buckets cumulative.
+func MergeHistograms(hs ...*Histogram) *Histogram {
+	var bounds []float64
+	for _, h := range hs {
+		for _, b := range h.Buckets {
+			bounds = append(bounds, b.UpperBound)
+		}
+	}
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+
+	merged := &Histogram{Buckets: make([]Bucket, len(bounds))}
+	for i, bound := range bounds {
+		merged.Buckets[i].UpperBound = bound
+	}
+	for _, h := range hs {
+		merged.Sum += h.Sum
+		merged.Count += h.Count
+		for i := range merged.Buckets {
+			merged.Buckets[i].Count += h.countAt(merged.Buckets[i].UpperBound)
+		}
+	}
+	return merged
+}
+
+// Delta returns the observations recorded between prev and h. If the bucket
+// layout changed or the counts went backwards the source was reset, and h is
+// returned as is.
+func (h *Histogram) Delta(prev *Histogram) *Histogram {
+	if prev == nil || prev.Count > h.Count || len(prev.Buckets) != len(h.Buckets) {
+		return h
+	}
+	delta := &Histogram{
+		Buckets: make([]Bucket, len(h.Buckets)),
+		Sum:     h.Sum - prev.Sum,
+		Count:   h.Count - prev.Count,
+	}
+	for i, b := range h.Buckets {
+		p := prev.Buckets[i]
+		if p.UpperBound != b.UpperBound || p.Count > b.Count {
+			return h
+		}
+		delta.Buckets[i] = Bucket{UpperBound: b.UpperBound, Count: b.Count - p.Count}
+	}
+	return delta
+}
+
+// Quantile estimates the q-quantile (0..1) by linear interpolation inside the
+// bucket that holds the target rank. Observations above the highest bound
+// are reported as that bound. It returns NaN for an empty histogram.
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 || len(h.Buckets) == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(h.Count)
+	i := sort.Search(len(h.Buckets), func(i int) bool { return float64(h.Buckets[i].Count) >= rank })
+	if i == len(h.Buckets) {
+		return h.Buckets[len(h.Buckets)-1].UpperBound
+	}
+
+	lower, lowerCount := 0.0, uint64(0)
+	if i > 0 {
+		lower, lowerCount = h.Buckets[i-1].UpperBound, h.Buckets[i-1].Count
+	} else if h.Buckets[0].UpperBound <= 0 {
+		return h.Buckets[0].UpperBound
+	}
+	upper, upperCount := h.Buckets[i].UpperBound, h.Buckets[i].Count
+	if upperCount == lowerCount {
+		return upper
+	}
+	return lower + (upper-lower)*(rank-float64(lowerCount))/float64(upperCount-lowerCount)
+}
+
+// LabelsKey renders labels in a stable order so samples of the same series
+// can be matched across pushes.
+func LabelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(labels[k])
+	}
+	return sb.String()
+}
+
+// WindowHistogram returns the observations of the histogram samples called
+// name that were recorded over the span of metrics, merged across label
+// sets. Each series contributes its latest snapshot minus its earliest one,
+// or the latest snapshot alone if it was only seen once. It returns nil if
+// no such samples exist.
+func WindowHistogram(metrics []Metric, name string) *Histogram {
+	first := make(map[string]*Histogram)
+	last := make(map[string]*Histogram)
+	for _, m := range metrics {
+		for _, s := range m.Samples {
+			if s.Name != name || s.Histogram == nil {
+				continue
+			}
+			key := LabelsKey(s.Labels)
+			if _, ok := first[key]; !ok {
+				first[key] = s.Histogram
+			}
+			last[key] = s.Histogram
+		}
+	}
+	if len(last) == 0 {
+		return nil
+	}
+
+	deltas := make([]*Histogram, 0, len(last))
+	for key, h := range last {
+		if f := first[key]; f != h {
+			h = h.Delta(f)
+		}
+		deltas = append(deltas, h)
+	}
+	return MergeHistograms(deltas...)
+}
+
+// LatestSummaries returns the most recent summary of every label set of the
+// samples called name, keyed by LabelsKey.
+func LatestSummaries(metrics []Metric, name string) map[string]*Summary {
+	summaries := make(map[string]*Summary)
+	for _, m := range metrics {
+		for _, s := range m.Samples {
+			if s.Name == name && s.Summary != nil {
+				summaries[LabelsKey(s.Labels)] = s.Summary
+			}
+		}
+	}
+	return summaries
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ipHistoryLimit caps the IP changes kept per name.
+const ipHistoryLimit = 20
+
+type IPChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	At   int64  `json:"at"`
+}
+
+// IPStatus describes the addresses a name was pushed from. IPs holds the
+// last push time of every address seen within the conflict window; more
+// than one of them means several agents share the name.
+type IPStatus struct {
+	Current  string           `json:"current"`
+	IPs      map[string]int64 `json:"ips"`
+	Conflict bool             `json:"conflict"`
+	Changes  []IPChange       `json:"changes"`
+}
+
+type ipHost struct {
+	current  string
+	seen     map[string]int64
+	changes  []IPChange
+	conflict bool
+}
+
+// IPTracker follows the source addresses of every name to catch agents that
+// were started with the same name, and to keep a history of address
+// changes. It uses the time pushes arrive, not the agent timestamps.
+type IPTracker struct {
+	mu     sync.Mutex
+	window int64
+	hosts  map[string]*ipHost
+}
+
+func NewIPTracker(window time.Duration) *IPTracker {
+	return &IPTracker{
+		window: int64(window / time.Second),
+		hosts:  make(map[string]*ipHost),
+	}
+}
+
+// Observe records a push of name from ip. It returns true when the push
+// starts a conflict, i.e. another address pushed the name within the window.
+func (t *IPTracker) Observe(name, ip string) bool {
+	now := time.Now().UTC().Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.hosts[name]
+	if !ok {
+		h = &ipHost{seen: make(map[string]int64)}
+		t.hosts[name] = h
+	}
+	maps.DeleteFunc(h.seen, func(_ string, last int64) bool { return now-last > t.window })
+
+	// An address that already pushed within the window is an interleaving
+	// agent, not a change; the conflict flag covers that case.
+	if _, recent := h.seen[ip]; h.current != "" && h.current != ip && !recent {
+		h.changes = append(h.changes, IPChange{From: h.current, To: ip, At: now})
+		if len(h.changes) > ipHistoryLimit {
+			h.changes = slices.Delete(h.changes, 0, len(h.changes)-ipHistoryLimit)
+		}
+	}
+	h.current = ip
+	h.seen[ip] = now
+
+	started := len(h.seen) > 1 && !h.conflict
+	h.conflict = len(h.seen) > 1
+	return started
+}
+
+// Status returns the IP status of name.
+func (t *IPTracker) Status(name string) (IPStatus, bool) {
+	now := time.Now().UTC().Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hosts[name]
+	if !ok {
+		return IPStatus{}, false
+	}
+	return t.status(h, now), true
+}
+
+// All returns the IP status of every name that pushed since startup.
+func (t *IPTracker) All() map[string]IPStatus {
+	now := time.Now().UTC().Unix()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := make(map[string]IPStatus, len(t.hosts))
+	for name, h := range t.hosts {
+		result[name] = t.status(h, now)
+	}
+	return result
+}
+
+func (t *IPTracker) status(h *ipHost, now int64) IPStatus {
+	s := IPStatus{
+		Current: h.current,
+		IPs:     make(map[string]int64, len(h.seen)),
+		Changes: slices.Clone(h.changes),
+	}
+	for ip, last := range h.seen {
+		if now-last <= t.window {
+			s.IPs[ip] = last
+		}
+	}
+	s.Conflict = len(s.IPs) > 1
+	return s
+}
+
+// Forget drops everything known about name.
+func (t *IPTracker) Forget(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.hosts, name)
+}
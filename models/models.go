@@ -1,11 +1,34 @@
 package models
 
 import (
+	"log"
+	"math"
+	"net/netip"
 	"slices"
+	"sort"
 	"sync"
 	"time"
 )
 
+const (
+	SampleGauge     = "gauge"
+	SampleCounter   = "counter"
+	SampleHistogram = "histogram"
+	SampleSummary   = "summary"
+)
+
+// Sample is a named value reported alongside the fixed host metrics, such
+// as disk usage or load average. Labels distinguish series that share a
+// name, e.g. one disk sample per mountpoint.
+type Sample struct {
+	Name      string
+	Value     float64
+	Type      string
+	Labels    map[string]string `json:",omitempty"`
+	Histogram *Histogram        `json:",omitempty"`
+	Summary   *Summary          `json:",omitempty"`
+}
+
 type Metric struct {
 	IP          string
 	Name        string
@@ -14,18 +37,105 @@ type Metric struct {
 	NetworkIn   float64
 	NetworkOut  float64
 	Timestamp   int64
+	Samples     []Sample `json:",omitempty"`
+}
+
+// series is the history of one allowed name. Every series has its own lock,
+// so pushes for different names never contend with each other and readers
+// only block the series they are copying. Raw samples are collected in head
+// and sealed into compressed chunks once it is full; reads decode only the
+// chunks that overlap the requested range.
+type series struct {
+	mu      sync.RWMutex
+	chunks  []*chunk
+	head    []Metric
+	rollups [][]Rollup
+	// seq is the log sequence number of the last sample, kept by DiskStore.
+	seq uint64
+}
+
+// last returns the timestamp of the newest sample.
+// It must be called with s.mu held.
+func (s *series) last() (int64, bool) {
+	if len(s.head) > 0 {
+		return s.head[len(s.head)-1].Timestamp, true
+	}
+	if len(s.chunks) > 0 {
+		return s.chunks[len(s.chunks)-1].maxT, true
+	}
+	return 0, false
+}
+
+// accepts reports whether a sample taken at ts may be appended. Samples must
+// be newer than the last one, as between and trim search by timestamp.
+// It must be called with s.mu held.
+func (s *series) accepts(ts int64) bool {
+	last, ok := s.last()
+	return !ok || ts > last
+}
+
+// append must be called with s.mu held, and only for accepted samples.
+func (s *series) append(m Metric) {
+	if len(s.head) > 0 && (len(s.head) >= chunkSize || !sameLayout(s.head[0], m)) {
+		s.chunks = append(s.chunks, newChunk(s.head))
+		s.head = make([]Metric, 0, chunkSize)
+	}
+	s.head = append(s.head, m)
+}
+
+// between returns a copy of the samples between from and to (inclusive).
+// It must be called with s.mu held.
+func (s *series) between(from, to int64) []Metric {
+	var out []Metric
+	i := sort.Search(len(s.chunks), func(i int) bool { return s.chunks[i].maxT >= from })
+	for ; i < len(s.chunks) && s.chunks[i].minT <= to; i++ {
+		out = s.chunks[i].decode(out)
+	}
+	out = append(out, metricsBetween(s.head, from, to)...)
+	return metricsBetween(out, from, to)
 }
 
+// all must be called with s.mu held.
+func (s *series) all() []Metric {
+	return s.between(math.MinInt64, math.MaxInt64)
+}
+
+// trim drops samples older than before. Chunks are dropped whole, so up to
+// one chunk of expired samples can outlive the retention.
+// It must be called with s.mu held.
+func (s *series) trim(before int64) {
+	i := sort.Search(len(s.chunks), func(i int) bool { return s.chunks[i].maxT >= before })
+	s.chunks = append([]*chunk(nil), s.chunks[i:]...)
+	if len(s.chunks) == 0 {
+		s.head = trimMetrics(s.head, before)
+	}
+}
+
+// reset must be called with s.mu held.
+func (s *series) reset(metrics []Metric) {
+	s.chunks = nil
+	s.head = nil
+	for _, m := range metrics {
+		s.append(m)
+	}
+}
+
+// MetricsStore is the in-memory backend. Its own lock only guards the set of
+// series and the allowlist; it is never held while samples are copied.
 type MetricsStore struct {
 	mu           sync.RWMutex
-	metrics      map[string][]Metric
+	series       map[string]*series
 	allowedNames []string
+	cidrs        map[string][]netip.Prefix
+	retention    Retention
 }
 
-func NewMetricsStore() *MetricsStore {
+func NewMetricsStore(retention Retention) *MetricsStore {
 	return &MetricsStore{
-		metrics:      make(map[string][]Metric),
+		series:       make(map[string]*series),
 		allowedNames: make([]string, 0, 20),
+		cidrs:        make(map[string][]netip.Prefix),
+		retention:    retention,
 	}
 }
 
@@ -33,39 +143,74 @@ func (ms *MetricsStore) AddAllowedName(name string) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
-	if !ms.IsAllowed(name) {
+	if _, exists := ms.series[name]; !exists {
+		ms.series[name] = &series{}
 		ms.allowedNames = append(ms.allowedNames, name)
 	}
 }
 
 func (ms *MetricsStore) IsAllowed(name string) bool {
-	return slices.Contains(ms.allowedNames, name)
+	return ms.get(name) != nil
+}
+
+// get returns the series of an allowed name, or nil.
+func (ms *MetricsStore) get(name string) *series {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.series[name]
+}
+
+// snapshotSeries returns the allowed names in order together with their
+// series, so callers can walk them without holding the store lock.
+func (ms *MetricsStore) snapshotSeries() ([]string, []*series) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	names := append([]string(nil), ms.allowedNames...)
+	list := make([]*series, len(names))
+	for i, name := range names {
+		list[i] = ms.series[name]
+	}
+	return names, list
 }
 
 func (ms *MetricsStore) AddMetric(name string, metric Metric) {
-	if !ms.IsAllowed(name) {
+	ms.addMetric(name, metric, 0)
+}
+
+// addMetric appends metric and records seq as the sequence number of the
+// last sample of the series.
+func (ms *MetricsStore) addMetric(name string, metric Metric, seq uint64) {
+	s := ms.get(name)
+	if s == nil {
 		return
 	}
 
-	ms.mu.Lock()
-	defer ms.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if _, exists := ms.metrics[name]; !exists {
-		ms.metrics[name] = make([]Metric, 0, 100)
+	if !s.accepts(metric.Timestamp) {
+		last, _ := s.last()
+		log.Printf("dropping metric of %s at %d: not newer than the last one at %d", name, metric.Timestamp, last)
+		return
 	}
-
-	ms.metrics[name] = append(ms.metrics[name], metric)
+	s.append(metric)
+	s.seq = seq
 }
 
-func (ms *MetricsStore) GetMetrics(name string) []Metric {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
-
-	if !ms.IsAllowed(name) {
-		return nil
+// accepts reports whether a metric of name taken at ts would be stored.
+func (ms *MetricsStore) accepts(name string, ts int64) bool {
+	s := ms.get(name)
+	if s == nil {
+		return false
 	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.accepts(ts)
+}
 
-	return ms.metrics[name]
+func (ms *MetricsStore) GetMetrics(name string) []Metric {
+	return ms.GetMetricsByName(name)
 }
 
 func (ms *MetricsStore) GetAllowedNames() []string {
@@ -80,7 +225,7 @@ func (ms *MetricsStore) RemoveName(name string) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
-	if !ms.IsAllowed(name) {
+	if _, exists := ms.series[name]; !exists {
 		return
 	}
 
@@ -93,58 +238,250 @@ func (ms *MetricsStore) RemoveName(name string) {
 	}
 
 	// Remove all metrics associated with this name
-	delete(ms.metrics, name)
+	delete(ms.series, name)
+	delete(ms.cidrs, name)
 }
 
-func (ms *MetricsStore) Cleanup() {
+func (ms *MetricsStore) SetAllowedCIDRs(name string, cidrs []netip.Prefix) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
-	for name, metrics := range ms.metrics {
-		if len(metrics) == 0 {
-			delete(ms.metrics, name)
-			continue
+	if _, exists := ms.series[name]; !exists {
+		return
+	}
+	if len(cidrs) == 0 {
+		delete(ms.cidrs, name)
+		return
+	}
+	ms.cidrs[name] = slices.Clone(cidrs)
+}
+
+func (ms *MetricsStore) AllowedCIDRs(name string) []netip.Prefix {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return slices.Clone(ms.cidrs[name])
+}
+
+// allCIDRs returns the CIDRs of every name that has any.
+func (ms *MetricsStore) allCIDRs() map[string][]netip.Prefix {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	result := make(map[string][]netip.Prefix, len(ms.cidrs))
+	for name, cidrs := range ms.cidrs {
+		result[name] = slices.Clone(cidrs)
+	}
+	return result
+}
+
+// Cleanup folds completed buckets into the rollup tiers and then drops raw
+// samples and rollups that are older than their retention.
+func (ms *MetricsStore) Cleanup() {
+	now := time.Now().UTC()
+	names, list := ms.snapshotSeries()
+	for i, s := range list {
+		s.mu.Lock()
+		s.cleanup(names[i], ms.retention, now)
+		s.mu.Unlock()
+	}
+}
+
+// cleanup must be called with s.mu held.
+func (s *series) cleanup(name string, retention Retention, now time.Time) {
+	if len(s.rollups) != len(retention.Tiers) {
+		s.rollups = make([][]Rollup, len(retention.Tiers))
+	}
+	for i, tier := range retention.Tiers {
+		step := int64(tier.Step / time.Second)
+		since := int64(0)
+		if n := len(s.rollups[i]); n > 0 {
+			since = s.rollups[i][n-1].Timestamp + step
 		}
+		if i == 0 {
+			metrics := s.between(since, math.MaxInt64)
+			s.rollups[i] = append(s.rollups[i], rollupMetrics(name, metrics, step, since, now.Unix())...)
+		} else {
+			s.rollups[i] = append(s.rollups[i], rollupRollups(name, s.rollups[i-1], step, since, now.Unix())...)
+		}
+	}
+	for i, tier := range retention.Tiers {
+		s.rollups[i] = trimRollups(s.rollups[i], now.Add(-tier.Retention).Unix())
+	}
+	s.trim(now.Add(-retention.Raw).Unix())
+}
+
+// Query returns the history of name between from and to (inclusive, unix
+// seconds), read from the finest tier whose retention still covers from.
+func (ms *MetricsStore) Query(name string, from, to int64) Series {
+	s := ms.get(name)
+	if s == nil {
+		return Series{}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tier := ms.retention.tierFor(time.Now().UTC(), from)
+	if tier < 0 || len(ms.retention.Tiers) == 0 {
+		metrics := s.between(from, to)
+		points := make([]Rollup, 0, len(metrics))
+		for _, m := range metrics {
+			points = append(points, metricToRollup(m))
+		}
+		return Series{Points: points}
+	}
 
-		// Keep only the last 100 metrics for each name
-		if len(metrics) > 100 {
-			ms.metrics[name] = metrics[len(metrics)-100:]
+	result := Series{Step: int64(ms.retention.Tiers[tier].Step / time.Second)}
+	if tier < len(s.rollups) {
+		for _, r := range s.rollups[tier] {
+			if r.Timestamp >= from && r.Timestamp <= to {
+				result.Points = append(result.Points, r)
+			}
 		}
 	}
+	return result
+}
+
+// GetMetricsRange returns the raw samples of name between from and to
+// (inclusive, unix seconds). When step is positive the samples are averaged
+// into buckets of step seconds, and when limit is positive only the latest
+// limit points are returned.
+func (ms *MetricsStore) GetMetricsRange(name string, from, to, step int64, limit int) []Metric {
+	s := ms.get(name)
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	metrics := s.between(from, to)
+	if step > 0 {
+		metrics = downsampleMetrics(metrics, step)
+	}
+	if limit > 0 && len(metrics) > limit {
+		metrics = metrics[len(metrics)-limit:]
+	}
+	return metrics
+}
+
+// metricsBetween relies on samples being appended in time order and returns
+// the sub-slice between from and to without copying it.
+func metricsBetween(metrics []Metric, from, to int64) []Metric {
+	lo := sort.Search(len(metrics), func(i int) bool { return metrics[i].Timestamp >= from })
+	hi := sort.Search(len(metrics), func(i int) bool { return metrics[i].Timestamp > to })
+	if lo >= hi {
+		return nil
+	}
+	return metrics[lo:hi]
+}
+
+func downsampleMetrics(metrics []Metric, step int64) []Metric {
+	var out []Metric
+	for i := 0; i < len(metrics); {
+		bucket := metrics[i].Timestamp - metrics[i].Timestamp%step
+		j := i
+		avg := Metric{Timestamp: bucket}
+		for ; j < len(metrics) && metrics[j].Timestamp < bucket+step; j++ {
+			avg.CPUUsage += metrics[j].CPUUsage
+			avg.MemoryUsage += metrics[j].MemoryUsage
+			avg.NetworkIn += metrics[j].NetworkIn
+			avg.NetworkOut += metrics[j].NetworkOut
+		}
+		n := float64(j - i)
+		// Generic samples are not averaged; the bucket keeps the latest set.
+		avg.IP = metrics[j-1].IP
+		avg.Name = metrics[j-1].Name
+		avg.Samples = metrics[j-1].Samples
+		avg.CPUUsage /= n
+		avg.MemoryUsage /= n
+		avg.NetworkIn /= n
+		avg.NetworkOut /= n
+		out = append(out, avg)
+		i = j
+	}
+	return out
+}
+
+func trimMetrics(metrics []Metric, before int64) []Metric {
+	return metrics[sort.Search(len(metrics), func(i int) bool { return metrics[i].Timestamp >= before }):]
+}
+
+func trimRollups(rollups []Rollup, before int64) []Rollup {
+	return rollups[sort.Search(len(rollups), func(i int) bool { return rollups[i].Timestamp >= before }):]
+}
+
+func (ms *MetricsStore) Close() error {
+	return nil
 }
 
 func (ms *MetricsStore) GetAllMetrics() map[string][]Metric {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
+	names, list := ms.snapshotSeries()
 
-	// Return a copy of the metrics map to avoid external modifications
-	metricsCopy := make(map[string][]Metric, len(ms.metrics))
-	for ip, metrics := range ms.metrics {
-		metricsCopy[ip] = append([]Metric(nil), metrics...)
+	// Return a copy of the metrics map to avoid external modifications. Each
+	// series is copied under its own lock, so writers to other names are
+	// never blocked by this.
+	metricsCopy := make(map[string][]Metric, len(names))
+	for i, s := range list {
+		s.mu.RLock()
+		if metrics := s.all(); len(metrics) > 0 {
+			metricsCopy[names[i]] = metrics
+		}
+		s.mu.RUnlock()
 	}
 	return metricsCopy
 }
 
-func (ms *MetricsStore) GetMetricsByName(name string) []Metric {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
+// copyMetrics is GetAllMetrics that also returns, for every name with
+// samples, the sequence number of its last sample as recorded by addMetric.
+func (ms *MetricsStore) copyMetrics() (map[string][]Metric, map[string]uint64) {
+	names, list := ms.snapshotSeries()
+
+	metricsCopy := make(map[string][]Metric, len(names))
+	seqs := make(map[string]uint64, len(names))
+	for i, s := range list {
+		s.mu.RLock()
+		if metrics := s.all(); len(metrics) > 0 {
+			metricsCopy[names[i]] = metrics
+			seqs[names[i]] = s.seq
+		}
+		s.mu.RUnlock()
+	}
+	return metricsCopy, seqs
+}
 
-	if !ms.IsAllowed(name) {
+func (ms *MetricsStore) GetMetricsByName(name string) []Metric {
+	s := ms.get(name)
+	if s == nil {
 		return nil
 	}
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	// Return a copy of the metrics for the given name to avoid external modifications
-	return append([]Metric(nil), ms.metrics[name]...)
+	return s.all()
+}
+
+func (ms *MetricsStore) Latest(name string) (Metric, bool) {
+	s := ms.get(name)
+	if s == nil {
+		return Metric{}, false
+	}
+	return s.latest()
 }
 
 func (ms *MetricsStore) AliveStatus(threshold int) map[string]interface{} {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
+	names, list := ms.snapshotSeries()
+	return aliveStatus(names, func(i int) (Metric, bool) { return list[i].latest() }, threshold)
+}
 
+// aliveStatus builds the /api/alive payload for names, where latest returns
+// the most recent sample of names[i].
+func aliveStatus(names []string, latest func(i int) (Metric, bool), threshold int) map[string]interface{} {
 	status := make(map[string]interface{})
-	for _, name := range ms.allowedNames {
-		metrics, exists := ms.metrics[name]
-		if !exists || len(metrics) == 0 {
+	for i, name := range names {
+		lastMetric, ok := latest(i)
+		if !ok {
 			status[name] = map[string]interface{}{
 				"alive":         false,
 				"latest-metric": nil,
@@ -153,7 +490,6 @@ func (ms *MetricsStore) AliveStatus(threshold int) map[string]interface{} {
 		}
 
 		// Check if the last metric is within the offline threshold
-		lastMetric := metrics[len(metrics)-1]
 		t := map[string]interface{}{
 			"latest-metric": lastMetric,
 		}
@@ -166,3 +502,51 @@ func (ms *MetricsStore) AliveStatus(threshold int) map[string]interface{} {
 	}
 	return status
 }
+
+func (s *series) latest() (Metric, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.head) > 0 {
+		return s.head[len(s.head)-1], true
+	}
+	if len(s.chunks) > 0 {
+		metrics := s.chunks[len(s.chunks)-1].decode(nil)
+		return metrics[len(metrics)-1], true
+	}
+	return Metric{}, false
+}
+
+func (ms *MetricsStore) getAllRollups() map[string][][]Rollup {
+	names, list := ms.snapshotSeries()
+
+	rollupsCopy := make(map[string][][]Rollup, len(names))
+	for i, s := range list {
+		s.mu.RLock()
+		t := make([][]Rollup, len(s.rollups))
+		for j, rollups := range s.rollups {
+			t[j] = append([]Rollup(nil), rollups...)
+		}
+		s.mu.RUnlock()
+		rollupsCopy[names[i]] = t
+	}
+	return rollupsCopy
+}
+
+// restore loads previously persisted history for an allowed name.
+func (ms *MetricsStore) restore(name string, metrics []Metric, rollups [][]Rollup) {
+	s := ms.get(name)
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if metrics != nil {
+		s.reset(metrics)
+	}
+	if rollups != nil {
+		s.rollups = rollups
+	}
+}
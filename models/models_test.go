@@ -0,0 +1,119 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkMetricsStore measures AddMetric from parallel goroutines, each
+// pushing its own host, while other goroutines read the store. With one
+// lock per series, writers only wait for readers copying the same host.
+// Run it with -cpu 1,2,4,8 to see how it scales.
+func BenchmarkMetricsStore(b *testing.B) {
+	const (
+		hosts   = 64
+		samples = 1000
+	)
+	for _, bc := range []struct {
+		readers string
+		read    func(ms *MetricsStore, i int)
+	}{
+		{"none", nil},
+		{"all", func(ms *MetricsStore, i int) { ms.GetAllMetrics() }},
+		{"range", func(ms *MetricsStore, i int) {
+			ms.GetMetricsRange(fmt.Sprintf("web-%02d", i%hosts), samples/2, samples, 0, 0)
+		}},
+	} {
+		read := bc.read
+		b.Run("readers="+bc.readers, func(b *testing.B) {
+			ms := NewMetricsStore(DefaultRetention())
+			for h := range hosts {
+				host := fmt.Sprintf("web-%02d", h)
+				ms.AddAllowedName(host)
+				for ts := int64(1); ts <= samples; ts++ {
+					ms.AddMetric(host, Metric{Name: host, IP: "10.0.0.1", CPUUsage: float64(ts % 100), Timestamp: ts})
+				}
+			}
+
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			var reads atomic.Int64
+			if read != nil {
+				for r := range 2 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := r; ; i++ {
+							select {
+							case <-done:
+								return
+							default:
+								read(ms, i)
+								reads.Add(1)
+							}
+						}
+					}()
+				}
+			}
+
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Every goroutine pushes its own host, so that timestamps
+				// only increase within a series.
+				name := fmt.Sprintf("writer-%02d", next.Add(1))
+				ms.AddAllowedName(name)
+				m := Metric{Name: name, IP: "10.0.0.1", CPUUsage: 12.5, MemoryUsage: 40, NetworkIn: 1e6, NetworkOut: 2e6}
+				for pb.Next() {
+					m.Timestamp++
+					ms.AddMetric(name, m)
+				}
+			})
+			b.StopTimer()
+			close(done)
+			wg.Wait()
+			b.ReportMetric(float64(reads.Load())/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func TestSeriesMetricsFromRollups(t *testing.T) {
+	ms := NewMetricsStore(Retention{
+		Raw:   time.Hour,
+		Tiers: []Tier{{Step: time.Minute, Retention: 24 * time.Hour}},
+	})
+	ms.AddAllowedName("web-01")
+	start := time.Now().UTC().Add(-3 * time.Hour).Truncate(time.Minute).Unix()
+	for ts := start; ts < start+600; ts += 10 {
+		ms.AddMetric("web-01", Metric{Name: "web-01", Timestamp: ts, CPUUsage: float64(ts-start) / 10})
+	}
+	ms.Cleanup()
+
+	if got := ms.GetMetricsRange("web-01", start, start+600, 0, 0); len(got) != 0 {
+		t.Fatalf("%d raw samples left beyond the raw retention", len(got))
+	}
+	series := ms.Query("web-01", start, start+600)
+	if series.Step != 60 {
+		t.Fatalf("step = %d, want 60", series.Step)
+	}
+	metrics := series.Metrics(0, 0)
+	if len(metrics) != 10 {
+		t.Fatalf("%d metrics, want 10", len(metrics))
+	}
+	// The first minute holds cpu 0..5.
+	if m := metrics[0]; m.Timestamp != start || m.CPUUsage != 2.5 || m.Name != "web-01" {
+		t.Errorf("first metric = %+v, want average cpu 2.5 at %d", m, start)
+	}
+
+	last := start + 540
+	coarse := series.Metrics(300, 1)
+	if len(coarse) != 1 || coarse[0].Timestamp != last-last%300 {
+		t.Errorf("step 300, limit 1 = %+v", coarse)
+	}
+	if got := (Series{}).Metrics(0, 0); got != nil {
+		t.Errorf("empty series = %v, want nil", got)
+	}
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Aggregate summarises one field of the samples that fall into a rollup
+// bucket.
+type Aggregate struct {
+	Avg float64
+	Min float64
+	Max float64
+}
+
+// Rollup is a downsampled bucket of metrics starting at Timestamp and
+// covering one tier step.
+type Rollup struct {
+	Name        string
+	Timestamp   int64
+	Count       int
+	CPUUsage    Aggregate
+	MemoryUsage Aggregate
+	NetworkIn   Aggregate
+	NetworkOut  Aggregate
+}
+
+// Series is the result of a history query. Step is zero when Points were
+// taken from raw samples, otherwise it is the width of the rollup tier in
+// seconds.
+type Series struct {
+	Step   int64
+	Points []Rollup
+}
+
+// Metrics returns the points as metrics holding the averages of their
+// buckets, in the shape of GetMetricsRange. A step coarser than the series
+// averages the points again, and a positive limit keeps the latest points.
+func (s Series) Metrics(step int64, limit int) []Metric {
+	if len(s.Points) == 0 {
+		return nil
+	}
+	metrics := make([]Metric, len(s.Points))
+	for i, r := range s.Points {
+		metrics[i] = Metric{
+			Name:        r.Name,
+			Timestamp:   r.Timestamp,
+			CPUUsage:    r.CPUUsage.Avg,
+			MemoryUsage: r.MemoryUsage.Avg,
+			NetworkIn:   r.NetworkIn.Avg,
+			NetworkOut:  r.NetworkOut.Avg,
+		}
+	}
+	if step > s.Step {
+		metrics = downsampleMetrics(metrics, step)
+	}
+	if limit > 0 && len(metrics) > limit {
+		metrics = metrics[len(metrics)-limit:]
+	}
+	return metrics
+}
+
+// Tier is one level of downsampled history.
+type Tier struct {
+	Step      time.Duration
+	Retention time.Duration
+}
+
+// Retention describes how long raw samples are kept and which rollup tiers
+// are built from them. Each tier is computed from the previous one (the
+// first from raw samples), so tiers must be ordered by increasing step.
+type Retention struct {
+	Raw   time.Duration
+	Tiers []Tier
+}
+
+func DefaultRetention() Retention {
+	return Retention{
+		Raw: 24 * time.Hour,
+		Tiers: []Tier{
+			{Step: time.Minute, Retention: 7 * 24 * time.Hour},
+			{Step: time.Hour, Retention: 90 * 24 * time.Hour},
+		},
+	}
+}
+
+// ParseRetention parses a raw retention such as "24h" and a tier list such
+// as "1m:7d,1h:90d". Durations accept a "d" suffix for days.
+func ParseRetention(raw, tiers string) (Retention, error) {
+	var r Retention
+	var err error
+	if r.Raw, err = ParseDuration(raw); err != nil {
+		return r, fmt.Errorf("invalid raw retention %q: %w", raw, err)
+	}
+
+	for _, spec := range strings.Split(tiers, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		step, keep, ok := strings.Cut(spec, ":")
+		if !ok {
+			return r, fmt.Errorf("invalid rollup tier %q: expected step:retention", spec)
+		}
+		var t Tier
+		if t.Step, err = ParseDuration(step); err != nil {
+			return r, fmt.Errorf("invalid rollup tier %q: %w", spec, err)
+		}
+		if t.Retention, err = ParseDuration(keep); err != nil {
+			return r, fmt.Errorf("invalid rollup tier %q: %w", spec, err)
+		}
+		if t.Step <= 0 {
+			return r, fmt.Errorf("invalid rollup tier %q: step must be positive", spec)
+		}
+		if n := len(r.Tiers); n > 0 && t.Step <= r.Tiers[n-1].Step {
+			return r, fmt.Errorf("invalid rollup tier %q: steps must increase", spec)
+		}
+		r.Tiers = append(r.Tiers, t)
+	}
+	return r, nil
+}
+
+// ParseDuration is time.ParseDuration with an extra "d" suffix for days.
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// tierFor returns the finest tier that still holds data at from, or -1 if
+// raw samples reach back that far.
+func (r Retention) tierFor(now time.Time, from int64) int {
+	if from >= now.Add(-r.Raw).Unix() {
+		return -1
+	}
+	for i, t := range r.Tiers {
+		if from >= now.Add(-t.Retention).Unix() {
+			return i
+		}
+	}
+	return len(r.Tiers) - 1
+}
+
+type rollupBuilder struct {
+	r   Rollup
+	sum [4]float64
+}
+
+func newRollupBuilder(name string, ts int64) *rollupBuilder {
+	b := &rollupBuilder{r: Rollup{Name: name, Timestamp: ts}}
+	for _, a := range b.r.fields() {
+		a.Min = math.Inf(1)
+		a.Max = math.Inf(-1)
+	}
+	return b
+}
+
+func (b *rollupBuilder) addMetric(m Metric) {
+	b.add(Rollup{
+		Count:       1,
+		CPUUsage:    Aggregate{m.CPUUsage, m.CPUUsage, m.CPUUsage},
+		MemoryUsage: Aggregate{m.MemoryUsage, m.MemoryUsage, m.MemoryUsage},
+		NetworkIn:   Aggregate{m.NetworkIn, m.NetworkIn, m.NetworkIn},
+		NetworkOut:  Aggregate{m.NetworkOut, m.NetworkOut, m.NetworkOut},
+	})
+}
+
+func (b *rollupBuilder) add(r Rollup) {
+	dst := b.r.fields()
+	for i, src := range r.fields() {
+		b.sum[i] += src.Avg * float64(r.Count)
+		dst[i].Min = math.Min(dst[i].Min, src.Min)
+		dst[i].Max = math.Max(dst[i].Max, src.Max)
+	}
+	b.r.Count += r.Count
+}
+
+func (b *rollupBuilder) build() Rollup {
+	for i, a := range b.r.fields() {
+		a.Avg = b.sum[i] / float64(b.r.Count)
+	}
+	return b.r
+}
+
+func (r *Rollup) fields() [4]*Aggregate {
+	return [4]*Aggregate{&r.CPUUsage, &r.MemoryUsage, &r.NetworkIn, &r.NetworkOut}
+}
+
+func metricToRollup(m Metric) Rollup {
+	b := newRollupBuilder(m.Name, m.Timestamp)
+	b.addMetric(m)
+	return b.build()
+}
+
+// rollupMetrics folds raw samples into buckets of step seconds. Only buckets
+// that start at or after since and have fully elapsed by now are emitted.
+func rollupMetrics(name string, metrics []Metric, step, since, now int64) []Rollup {
+	var out []Rollup
+	var cur *rollupBuilder
+	for _, m := range metrics {
+		bucket := m.Timestamp - m.Timestamp%step
+		if bucket < since || bucket+step > now {
+			continue
+		}
+		if cur != nil && cur.r.Timestamp != bucket {
+			out = append(out, cur.build())
+			cur = nil
+		}
+		if cur == nil {
+			cur = newRollupBuilder(name, bucket)
+		}
+		cur.addMetric(m)
+	}
+	if cur != nil {
+		out = append(out, cur.build())
+	}
+	return out
+}
+
+// rollupRollups merges finer rollups into buckets of step seconds, with the
+// same bucket rules as rollupMetrics.
+func rollupRollups(name string, rollups []Rollup, step, since, now int64) []Rollup {
+	var out []Rollup
+	var cur *rollupBuilder
+	for _, r := range rollups {
+		bucket := r.Timestamp - r.Timestamp%step
+		if bucket < since || bucket+step > now {
+			continue
+		}
+		if cur != nil && cur.r.Timestamp != bucket {
+			out = append(out, cur.build())
+			cur = nil
+		}
+		if cur == nil {
+			cur = newRollupBuilder(name, bucket)
+		}
+		cur.add(r)
+	}
+	if cur != nil {
+		out = append(out, cur.build())
+	}
+	return out
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SelfMetrics counts events of the server itself, such as rejected calls,
+// so operators can see them without reading the logs.
+type SelfMetrics struct {
+	counters sync.Map // name -> *atomic.Uint64
+}
+
+func NewSelfMetrics() *SelfMetrics {
+	return &SelfMetrics{}
+}
+
+// Inc adds one to the counter called name.
+func (m *SelfMetrics) Inc(name string) {
+	c, ok := m.counters.Load(name)
+	if !ok {
+		c, _ = m.counters.LoadOrStore(name, new(atomic.Uint64))
+	}
+	c.(*atomic.Uint64).Add(1)
+}
+
+// Snapshot returns the current value of every counter.
+func (m *SelfMetrics) Snapshot() map[string]uint64 {
+	result := make(map[string]uint64)
+	m.counters.Range(func(k, v any) bool {
+		result[k.(string)] = v.(*atomic.Uint64).Load()
+		return true
+	})
+	return result
+}
@@ -0,0 +1,528 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	_ "modernc.org/sqlite"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS allowed_names (
+	id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE
+);
+CREATE TABLE IF NOT EXISTS allowed_cidrs (
+	name TEXT NOT NULL,
+	cidr TEXT NOT NULL,
+	PRIMARY KEY (name, cidr)
+);
+CREATE TABLE IF NOT EXISTS alert_rules (
+	position INTEGER PRIMARY KEY,
+	rule     TEXT    NOT NULL
+);
+CREATE TABLE IF NOT EXISTS metrics (
+	name        TEXT    NOT NULL,
+	ts          INTEGER NOT NULL,
+	ip          TEXT    NOT NULL,
+	cpu         REAL    NOT NULL,
+	memory      REAL    NOT NULL,
+	network_in  REAL    NOT NULL,
+	network_out REAL    NOT NULL,
+	samples     TEXT
+);
+CREATE INDEX IF NOT EXISTS metrics_name_ts ON metrics (name, ts);
+CREATE TABLE IF NOT EXISTS rollups (
+	name            TEXT    NOT NULL,
+	tier            INTEGER NOT NULL,
+	ts              INTEGER NOT NULL,
+	count           INTEGER NOT NULL,
+	cpu_avg         REAL    NOT NULL,
+	cpu_min         REAL    NOT NULL,
+	cpu_max         REAL    NOT NULL,
+	memory_avg      REAL    NOT NULL,
+	memory_min      REAL    NOT NULL,
+	memory_max      REAL    NOT NULL,
+	network_in_avg  REAL    NOT NULL,
+	network_in_min  REAL    NOT NULL,
+	network_in_max  REAL    NOT NULL,
+	network_out_avg REAL    NOT NULL,
+	network_out_min REAL    NOT NULL,
+	network_out_max REAL    NOT NULL,
+	PRIMARY KEY (name, tier, ts)
+);
+`
+
+const (
+	metricColumns = `name, ts, ip, cpu, memory, network_in, network_out, samples`
+	rollupColumns = `name, ts, count,
+		cpu_avg, cpu_min, cpu_max, memory_avg, memory_min, memory_max,
+		network_in_avg, network_in_min, network_in_max, network_out_avg, network_out_min, network_out_max`
+)
+
+// SQLiteStore keeps samples, rollups and the allowlist in an embedded SQLite
+// database, so small deployments get durable history that can be inspected
+// with plain SQL. The allowlist is cached in memory because it is checked on
+// every push.
+type SQLiteStore struct {
+	db        *sql.DB
+	retention Retention
+
+	mu           sync.RWMutex
+	allowedNames []string
+	cidrs        map[string][]netip.Prefix
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+func OpenSQLiteStore(path string, retention Retention) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite serialises writers anyway; a single connection avoids
+	// SQLITE_BUSY errors between our own goroutines.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	ss := &SQLiteStore{db: db, retention: retention, cidrs: make(map[string][]netip.Prefix)}
+	rows, err := db.Query(`SELECT name FROM allowed_names ORDER BY id`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			db.Close()
+			return nil, err
+		}
+		ss.allowedNames = append(ss.allowedNames, name)
+	}
+	if err := rows.Err(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := ss.loadCIDRs(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ss, nil
+}
+
+func (ss *SQLiteStore) loadCIDRs() error {
+	rows, err := ss.db.Query(`SELECT name, cidr FROM allowed_cidrs ORDER BY rowid`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, cidr string
+		if err := rows.Scan(&name, &cidr); err != nil {
+			return err
+		}
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			log.Printf("sqlite store: skip invalid cidr %q of %s: %v", cidr, name, err)
+			continue
+		}
+		ss.cidrs[name] = append(ss.cidrs[name], p)
+	}
+	return rows.Err()
+}
+
+func (ss *SQLiteStore) AddAllowedName(name string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if slices.Contains(ss.allowedNames, name) {
+		return
+	}
+	if _, err := ss.db.Exec(`INSERT OR IGNORE INTO allowed_names (name) VALUES (?)`, name); err != nil {
+		log.Printf("sqlite store: add allowed name failed: %v", err)
+		return
+	}
+	ss.allowedNames = append(ss.allowedNames, name)
+}
+
+func (ss *SQLiteStore) IsAllowed(name string) bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return slices.Contains(ss.allowedNames, name)
+}
+
+func (ss *SQLiteStore) GetAllowedNames() []string {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	// Return a copy of the allowed names to avoid external modifications
+	return append([]string(nil), ss.allowedNames...)
+}
+
+func (ss *SQLiteStore) RemoveName(name string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	i := slices.Index(ss.allowedNames, name)
+	if i < 0 {
+		return
+	}
+
+	tx, err := ss.db.Begin()
+	if err != nil {
+		log.Printf("sqlite store: remove name failed: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		`DELETE FROM allowed_names WHERE name = ?`,
+		`DELETE FROM allowed_cidrs WHERE name = ?`,
+		`DELETE FROM metrics WHERE name = ?`,
+		`DELETE FROM rollups WHERE name = ?`,
+	} {
+		if _, err := tx.Exec(q, name); err != nil {
+			log.Printf("sqlite store: remove name failed: %v", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("sqlite store: remove name failed: %v", err)
+		return
+	}
+	ss.allowedNames = slices.Delete(ss.allowedNames, i, i+1)
+	delete(ss.cidrs, name)
+}
+
+func (ss *SQLiteStore) SetAllowedCIDRs(name string, cidrs []netip.Prefix) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if !slices.Contains(ss.allowedNames, name) {
+		return
+	}
+
+	tx, err := ss.db.Begin()
+	if err != nil {
+		log.Printf("sqlite store: set cidrs failed: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM allowed_cidrs WHERE name = ?`, name); err != nil {
+		log.Printf("sqlite store: set cidrs failed: %v", err)
+		return
+	}
+	for _, p := range cidrs {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO allowed_cidrs (name, cidr) VALUES (?, ?)`, name, p.String()); err != nil {
+			log.Printf("sqlite store: set cidrs failed: %v", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("sqlite store: set cidrs failed: %v", err)
+		return
+	}
+	if len(cidrs) == 0 {
+		delete(ss.cidrs, name)
+		return
+	}
+	ss.cidrs[name] = slices.Clone(cidrs)
+}
+
+func (ss *SQLiteStore) AllowedCIDRs(name string) []netip.Prefix {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return slices.Clone(ss.cidrs[name])
+}
+
+// LoadAlertRules returns the alert rules in order, one JSON document per
+// rule. The rules themselves belong to the alerts package.
+func (ss *SQLiteStore) LoadAlertRules() ([]json.RawMessage, error) {
+	rows, err := ss.db.Query(`SELECT rule FROM alert_rules ORDER BY position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []json.RawMessage
+	for rows.Next() {
+		var rule string
+		if err := rows.Scan(&rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, json.RawMessage(rule))
+	}
+	return rules, rows.Err()
+}
+
+// SaveAlertRules replaces every alert rule in a single transaction.
+func (ss *SQLiteStore) SaveAlertRules(rules []json.RawMessage) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM alert_rules`); err != nil {
+		return err
+	}
+	for i, rule := range rules {
+		if _, err := tx.Exec(`INSERT INTO alert_rules (position, rule) VALUES (?, ?)`, i, string(rule)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (ss *SQLiteStore) AddMetric(name string, metric Metric) {
+	if !ss.IsAllowed(name) {
+		return
+	}
+
+	var samples sql.NullString
+	if len(metric.Samples) > 0 {
+		data, err := json.Marshal(metric.Samples)
+		if err != nil {
+			log.Printf("sqlite store: encode samples failed: %v", err)
+			return
+		}
+		samples = sql.NullString{String: string(data), Valid: true}
+	}
+	// The name may be removed after the check above; checking it again in
+	// the insert keeps samples of removed names out of the table.
+	_, err := ss.db.Exec(`INSERT INTO metrics (`+metricColumns+`) SELECT ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE EXISTS (SELECT 1 FROM allowed_names WHERE name = ?)`,
+		name, metric.Timestamp, metric.IP, metric.CPUUsage, metric.MemoryUsage, metric.NetworkIn, metric.NetworkOut, samples, name)
+	if err != nil {
+		log.Printf("sqlite store: add metric failed: %v", err)
+	}
+}
+
+func (ss *SQLiteStore) GetMetrics(name string) []Metric {
+	return ss.GetMetricsRange(name, math.MinInt64, math.MaxInt64, 0, 0)
+}
+
+func (ss *SQLiteStore) GetMetricsRange(name string, from, to, step int64, limit int) []Metric {
+	if !ss.IsAllowed(name) {
+		return nil
+	}
+
+	var metrics []Metric
+	var err error
+	if step == 0 && limit > 0 {
+		metrics, err = ss.queryMetrics(`SELECT * FROM (SELECT `+metricColumns+` FROM metrics
+			WHERE name = ? AND ts >= ? AND ts <= ? ORDER BY ts DESC, rowid DESC LIMIT ?) ORDER BY ts`, name, from, to, limit)
+		return logQueryError(metrics, err)
+	}
+
+	metrics, err = ss.queryMetrics(`SELECT `+metricColumns+` FROM metrics
+		WHERE name = ? AND ts >= ? AND ts <= ? ORDER BY ts, rowid`, name, from, to)
+	if err != nil {
+		return logQueryError(metrics, err)
+	}
+	if step > 0 {
+		metrics = downsampleMetrics(metrics, step)
+	}
+	if limit > 0 && len(metrics) > limit {
+		metrics = metrics[len(metrics)-limit:]
+	}
+	return metrics
+}
+
+func (ss *SQLiteStore) GetAllMetrics() map[string][]Metric {
+	all := make(map[string][]Metric)
+	for _, name := range ss.GetAllowedNames() {
+		if metrics := ss.GetMetrics(name); len(metrics) > 0 {
+			all[name] = metrics
+		}
+	}
+	return all
+}
+
+func (ss *SQLiteStore) Latest(name string) (Metric, bool) {
+	metrics, err := ss.queryMetrics(`SELECT `+metricColumns+` FROM metrics
+		WHERE name = ? ORDER BY ts DESC, rowid DESC LIMIT 1`, name)
+	if err != nil {
+		log.Printf("sqlite store: query latest metric failed: %v", err)
+	}
+	if len(metrics) == 0 {
+		return Metric{}, false
+	}
+	return metrics[0], true
+}
+
+func (ss *SQLiteStore) AliveStatus(threshold int) map[string]interface{} {
+	names := ss.GetAllowedNames()
+	return aliveStatus(names, func(i int) (Metric, bool) { return ss.Latest(names[i]) }, threshold)
+}
+
+func (ss *SQLiteStore) Query(name string, from, to int64) Series {
+	if !ss.IsAllowed(name) {
+		return Series{}
+	}
+
+	tier := ss.retention.tierFor(time.Now().UTC(), from)
+	if tier < 0 || len(ss.retention.Tiers) == 0 {
+		metrics := ss.GetMetricsRange(name, from, to, 0, 0)
+		points := make([]Rollup, 0, len(metrics))
+		for _, m := range metrics {
+			points = append(points, metricToRollup(m))
+		}
+		return Series{Points: points}
+	}
+
+	rollups, err := ss.queryRollups(name, tier, from, to)
+	if err != nil {
+		log.Printf("sqlite store: query rollups failed: %v", err)
+	}
+	return Series{
+		Step:   int64(ss.retention.Tiers[tier].Step / time.Second),
+		Points: rollups,
+	}
+}
+
+// Cleanup folds completed buckets into the rollup tiers and then drops raw
+// samples and rollups that are older than their retention.
+func (ss *SQLiteStore) Cleanup() {
+	now := time.Now().UTC()
+	for _, name := range ss.GetAllowedNames() {
+		if err := ss.cleanup(name, now); err != nil {
+			log.Printf("sqlite store: cleanup of %s failed: %v", name, err)
+		}
+	}
+	if _, err := ss.db.Exec(`DELETE FROM metrics WHERE ts < ?`, now.Add(-ss.retention.Raw).Unix()); err != nil {
+		log.Printf("sqlite store: trim metrics failed: %v", err)
+	}
+	for i, tier := range ss.retention.Tiers {
+		if _, err := ss.db.Exec(`DELETE FROM rollups WHERE tier = ? AND ts < ?`, i, now.Add(-tier.Retention).Unix()); err != nil {
+			log.Printf("sqlite store: trim rollups failed: %v", err)
+		}
+	}
+}
+
+func (ss *SQLiteStore) cleanup(name string, now time.Time) error {
+	for i, tier := range ss.retention.Tiers {
+		step := int64(tier.Step / time.Second)
+		var last sql.NullInt64
+		if err := ss.db.QueryRow(`SELECT MAX(ts) FROM rollups WHERE name = ? AND tier = ?`, name, i).Scan(&last); err != nil {
+			return err
+		}
+		since := int64(0)
+		if last.Valid {
+			since = last.Int64 + step
+		}
+
+		var rollups []Rollup
+		if i == 0 {
+			metrics := ss.GetMetricsRange(name, since, math.MaxInt64, 0, 0)
+			rollups = rollupMetrics(name, metrics, step, since, now.Unix())
+		} else {
+			prev, err := ss.queryRollups(name, i-1, since, math.MaxInt64)
+			if err != nil {
+				return err
+			}
+			rollups = rollupRollups(name, prev, step, since, now.Unix())
+		}
+		if err := ss.insertRollups(i, rollups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *SQLiteStore) insertRollups(tier int, rollups []Rollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Like AddMetric, skip names that were removed while they were rolled up.
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO rollups (tier, ` + rollupColumns + `)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE EXISTS (SELECT 1 FROM allowed_names WHERE name = ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range rollups {
+		_, err := stmt.Exec(tier, r.Name, r.Timestamp, r.Count,
+			r.CPUUsage.Avg, r.CPUUsage.Min, r.CPUUsage.Max,
+			r.MemoryUsage.Avg, r.MemoryUsage.Min, r.MemoryUsage.Max,
+			r.NetworkIn.Avg, r.NetworkIn.Min, r.NetworkIn.Max,
+			r.NetworkOut.Avg, r.NetworkOut.Min, r.NetworkOut.Max, r.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (ss *SQLiteStore) Close() error {
+	return ss.db.Close()
+}
+
+func (ss *SQLiteStore) queryMetrics(query string, args ...any) ([]Metric, error) {
+	rows, err := ss.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []Metric
+	for rows.Next() {
+		var m Metric
+		var samples sql.NullString
+		if err := rows.Scan(&m.Name, &m.Timestamp, &m.IP, &m.CPUUsage, &m.MemoryUsage, &m.NetworkIn, &m.NetworkOut, &samples); err != nil {
+			return metrics, err
+		}
+		if samples.Valid {
+			if err := json.Unmarshal([]byte(samples.String), &m.Samples); err != nil {
+				return metrics, err
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+func (ss *SQLiteStore) queryRollups(name string, tier int, from, to int64) ([]Rollup, error) {
+	rows, err := ss.db.Query(`SELECT `+rollupColumns+` FROM rollups
+		WHERE name = ? AND tier = ? AND ts >= ? AND ts <= ? ORDER BY ts`, name, tier, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollups []Rollup
+	for rows.Next() {
+		var r Rollup
+		err := rows.Scan(&r.Name, &r.Timestamp, &r.Count,
+			&r.CPUUsage.Avg, &r.CPUUsage.Min, &r.CPUUsage.Max,
+			&r.MemoryUsage.Avg, &r.MemoryUsage.Min, &r.MemoryUsage.Max,
+			&r.NetworkIn.Avg, &r.NetworkIn.Min, &r.NetworkIn.Max,
+			&r.NetworkOut.Avg, &r.NetworkOut.Min, &r.NetworkOut.Max)
+		if err != nil {
+			return rollups, err
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, rows.Err()
+}
+
+func logQueryError(metrics []Metric, err error) []Metric {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("sqlite store: query metrics failed: %v", err)
+	}
+	return metrics
+}
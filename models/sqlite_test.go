@@ -0,0 +1,44 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A name can be removed between the allowlist check and the insert of
+// AddMetric or of a rollup. Removing it from the table behind the store's
+// back opens that window deterministically.
+func TestSQLiteStoreSkipsRemovedNames(t *testing.T) {
+	ss, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "mon.db"), Retention{
+		Raw:   24 * time.Hour,
+		Tiers: []Tier{{Step: time.Minute, Retention: 7 * 24 * time.Hour}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	ss.AddAllowedName("web-01")
+	now := time.Now().UTC().Unix()
+	ss.AddMetric("web-01", Metric{Name: "web-01", Timestamp: now - 600})
+
+	if _, err := ss.db.Exec(`DELETE FROM allowed_names WHERE name = ?`, "web-01"); err != nil {
+		t.Fatal(err)
+	}
+	ss.AddMetric("web-01", Metric{Name: "web-01", Timestamp: now - 300})
+	ss.Cleanup()
+
+	var metrics, rollups int
+	if err := ss.db.QueryRow(`SELECT COUNT(*) FROM metrics WHERE name = ?`, "web-01").Scan(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.db.QueryRow(`SELECT COUNT(*) FROM rollups WHERE name = ?`, "web-01").Scan(&rollups); err != nil {
+		t.Fatal(err)
+	}
+	if metrics != 1 {
+		t.Errorf("%d metric rows, want only the one added before the removal", metrics)
+	}
+	if rollups != 0 {
+		t.Errorf("%d rollup rows of a removed name, want 0", rollups)
+	}
+}
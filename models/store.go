@@ -0,0 +1,31 @@
+package models
+
+import "net/netip"
+
+// Store is the contract every metrics storage backend implements.
+// MonitoringService and the web API only talk to a Store, so backends can
+// be swapped without touching the ingest or query paths.
+type Store interface {
+	AddAllowedName(name string)
+	IsAllowed(name string) bool
+	GetAllowedNames() []string
+	RemoveName(name string)
+	// SetAllowedCIDRs limits the source addresses name may be pushed from.
+	// An empty list lifts the limit.
+	SetAllowedCIDRs(name string, cidrs []netip.Prefix)
+	AllowedCIDRs(name string) []netip.Prefix
+
+	AddMetric(name string, metric Metric)
+	GetMetrics(name string) []Metric
+	GetMetricsRange(name string, from, to, step int64, limit int) []Metric
+	GetAllMetrics() map[string][]Metric
+	// Latest returns the most recent sample of name.
+	Latest(name string) (Metric, bool)
+	AliveStatus(threshold int) map[string]interface{}
+	Query(name string, from, to int64) Series
+
+	Cleanup()
+	Close() error
+}
+
+var _ Store = (*MetricsStore)(nil)
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Role is the access level of an HTTP API key. Each role includes the
+// permissions of the roles below it.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+var roleLevels = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+func ParseRole(s string) (Role, error) {
+	r := Role(strings.ToLower(s))
+	if _, ok := roleLevels[r]; !ok {
+		return "", fmt.Errorf("unknown role: %s", s)
+	}
+	return r, nil
+}
+
+// Allows reports whether r grants the permissions of required.
+func (r Role) Allows(required Role) bool {
+	return roleLevels[r] > 0 && roleLevels[r] >= roleLevels[required]
+}
+
+// Token is a credential. Agent tokens are bound to the allowed name in Name;
+// API keys carry a Role and use Name as a label. Only the SHA-256 hash of
+// the secret is kept; the secret itself is shown once on issue.
+type Token struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Role      Role   `json:"role,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	LastUsed  int64  `json:"last_used,omitempty"`
+}
+
+// TokenStore keeps tokens in a JSON file so that a leaked token can be
+// revoked without rotating every other one.
+type TokenStore struct {
+	mu     sync.RWMutex
+	path   string
+	prefix string
+	tokens []Token
+	byHash map[string]int
+}
+
+// OpenTokenStore loads the tokens kept in path. A missing file is an empty
+// store; it is created on the first issue. Issued secrets start with prefix,
+// which lets Verify skip hashing tokens of another kind.
+func OpenTokenStore(path, prefix string) (*TokenStore, error) {
+	s := &TokenStore{path: path, prefix: prefix}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.tokens); err != nil {
+			return nil, err
+		}
+	}
+	s.reindex()
+	return s, nil
+}
+
+func (s *TokenStore) reindex() {
+	s.byHash = make(map[string]int, len(s.tokens))
+	for i, t := range s.tokens {
+		s.byHash[t.Hash] = i
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Issue creates a token and returns its metadata together with the secret,
+// which cannot be recovered afterwards.
+func (s *TokenStore) Issue(name string, role Role) (Token, string, error) {
+	id, err := randomHex(6)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return Token{}, "", err
+	}
+	token := s.prefix + id + "_" + secret
+	t := Token{
+		ID:        id,
+		Name:      name,
+		Role:      role,
+		Hash:      hashToken(token),
+		CreatedAt: time.Now().UTC().Unix(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = append(s.tokens, t)
+	s.reindex()
+	if err := s.save(); err != nil {
+		s.tokens = s.tokens[:len(s.tokens)-1]
+		s.reindex()
+		return Token{}, "", err
+	}
+	return t, token, nil
+}
+
+// Lookup returns the token matching the secret.
+func (s *TokenStore) Lookup(token string) (Token, bool) {
+	if !strings.HasPrefix(token, s.prefix) {
+		return Token{}, false
+	}
+	hash := hashToken(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.byHash[hash]
+	if !ok {
+		return Token{}, false
+	}
+	// LastUsed is only kept in memory between writes to avoid a file write
+	// on every request.
+	s.tokens[i].LastUsed = time.Now().UTC().Unix()
+	return s.tokens[i], true
+}
+
+// Verify returns the name a token is bound to.
+func (s *TokenStore) Verify(token string) (string, bool) {
+	t, ok := s.Lookup(token)
+	return t.Name, ok
+}
+
+// List returns the tokens of name, or of every name if name is empty,
+// without their hashes.
+func (s *TokenStore) List(name string) []Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		if name != "" && t.Name != name {
+			continue
+		}
+		t.Hash = ""
+		result = append(result, t)
+	}
+	return result
+}
+
+// Revoke deletes the token with the given id and reports whether it existed.
+func (s *TokenStore) Revoke(id string) (bool, error) {
+	return s.revoke(func(t Token) bool { return t.ID == id })
+}
+
+// RevokeName deletes every token bound to name.
+func (s *TokenStore) RevokeName(name string) (bool, error) {
+	return s.revoke(func(t Token) bool { return t.Name == name })
+}
+
+func (s *TokenStore) revoke(match func(Token) bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.tokens
+	s.tokens = slices.DeleteFunc(slices.Clone(s.tokens), match)
+	if len(s.tokens) == len(old) {
+		return false, nil
+	}
+	s.reindex()
+	if err := s.save(); err != nil {
+		s.tokens = old
+		s.reindex()
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *TokenStore) save() error {
+	return writeFileAtomic(s.path, s.tokens)
+}
@@ -0,0 +1,205 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stupidrun/mon/alerts"
+	"github.com/stupidrun/mon/models"
+	"os"
+	"time"
+)
+
+const (
+	TypeWebhook = "webhook"
+	TypeSlack   = "slack"
+	TypeEmail   = "email"
+)
+
+const (
+	defaultRetries    = 3
+	defaultTimeout    = 10 * time.Second
+	initialBackoff    = time.Second
+	maxBackoff        = 30 * time.Second
+	defaultRatePerMin = 30
+	defaultRateBurst  = 10
+)
+
+// Config describes one channel in the channels file. Which fields matter
+// depends on Type; see the senders.
+type Config struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Template renders the webhook body, the Slack text or the email body
+	// from an alerts.Event. Subject is the email subject template.
+	Template string `json:"template,omitempty"`
+	Subject  string `json:"subject,omitempty"`
+
+	// Slack-compatible overrides; empty fields keep the webhook defaults.
+	Channel   string `json:"channel,omitempty"`
+	Username  string `json:"username,omitempty"`
+	IconEmoji string `json:"icon_emoji,omitempty"`
+
+	SMTPAddr string   `json:"smtp_addr,omitempty"`
+	TLS      bool     `json:"tls,omitempty"`
+	User     string   `json:"user,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+
+	// Retries is how many times a failed send is retried, with the wait
+	// doubling from one second. Negative disables retries.
+	Retries    int    `json:"retries,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+	RatePerMin int    `json:"rate_per_min,omitempty"`
+	RateBurst  int    `json:"rate_burst,omitempty"`
+}
+
+// Rate returns the send rate limit of the channel. A negative RatePerMin
+// disables limiting.
+func (c Config) Rate() (perMin, burst int) {
+	perMin, burst = c.RatePerMin, c.RateBurst
+	if perMin == 0 {
+		perMin = defaultRatePerMin
+	}
+	if burst <= 0 {
+		burst = defaultRateBurst
+	}
+	return max(perMin, 0), burst
+}
+
+// Limiter is consulted before every send; see middlewares.RateLimiter.
+type Limiter interface {
+	Allow(key string) (bool, time.Duration)
+}
+
+type sender interface {
+	send(e alerts.Event) error
+}
+
+// permanentError marks failures that retrying cannot fix, such as a
+// rejected request or a broken template.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Channel is a configured destination for events. It implements
+// alerts.Notifier.
+type Channel struct {
+	name    string
+	typ     string
+	sender  sender
+	limiter Limiter
+	retries int
+}
+
+// NewChannel validates cfg. limiter may be nil to send without limit.
+func NewChannel(cfg Config, limiter Limiter) (*Channel, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("channel name cannot be empty")
+	}
+	timeout := defaultTimeout
+	if cfg.Timeout != "" {
+		d, err := models.ParseDuration(cfg.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid timeout: %s", cfg.Timeout)
+		}
+		timeout = d
+	}
+
+	var s sender
+	var err error
+	switch cfg.Type {
+	case TypeWebhook:
+		s, err = newWebhook(cfg, timeout)
+	case TypeSlack:
+		s, err = newSlack(cfg, timeout)
+	case TypeEmail:
+		s, err = newEmail(cfg, timeout)
+	default:
+		err = fmt.Errorf("unknown channel type: %s", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	retries := cfg.Retries
+	switch {
+	case retries == 0:
+		retries = defaultRetries
+	case retries < 0:
+		retries = 0
+	}
+	return &Channel{
+		name:    cfg.Name,
+		typ:     cfg.Type,
+		sender:  s,
+		limiter: limiter,
+		retries: retries,
+	}, nil
+}
+
+func (c *Channel) Name() string { return c.name }
+func (c *Channel) Type() string { return c.typ }
+
+// Notify sends e, retrying transient failures with exponential backoff.
+// Events over the channel's rate limit are dropped with an error.
+func (c *Channel) Notify(e alerts.Event) error {
+	if c.limiter != nil {
+		if ok, _ := c.limiter.Allow(c.name); !ok {
+			return fmt.Errorf("channel %s: rate limited, dropping %s event of %s", c.name, e.Kind, e.Host)
+		}
+	}
+	backoff := initialBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.sender.send(e)
+		var perm permanentError
+		if err == nil || errors.As(err, &perm) || attempt >= c.retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBackoff)
+	}
+	if err != nil {
+		return fmt.Errorf("channel %s: %w", c.name, err)
+	}
+	return nil
+}
+
+// Test sends e once, bypassing the rate limit and retries, so that the
+// caller sees the outcome right away.
+func (c *Channel) Test(e alerts.Event) error {
+	return c.sender.send(e)
+}
+
+// LoadConfigs reads the channels file, a JSON array of Config. A missing
+// file means no channels.
+func LoadConfigs(path string) ([]Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(configs))
+	for _, cfg := range configs {
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("duplicate channel name: %s", cfg.Name)
+		}
+		seen[cfg.Name] = true
+	}
+	return configs, nil
+}
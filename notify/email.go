@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/stupidrun/mon/alerts"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// email sends a plain text mail through SMTPAddr. With TLS set the
+// connection is TLS from the start (usually port 465); otherwise STARTTLS is
+// used when the server offers it. Credentials are only sent over TLS or to
+// localhost, as net/smtp enforces.
+type email struct {
+	addr      string
+	host      string
+	tls       bool
+	tlsConfig *tls.Config
+	auth      smtp.Auth
+	from      string
+	to        []string
+	subject   *template.Template
+	body      *template.Template
+	timeout   time.Duration
+}
+
+func newEmail(cfg Config, timeout time.Duration) (*email, error) {
+	host, _, err := net.SplitHostPort(cfg.SMTPAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp_addr: %q", cfg.SMTPAddr)
+	}
+	if cfg.From == "" || len(cfg.To) == 0 {
+		return nil, errors.New("email channel needs from and to")
+	}
+	for _, addr := range append([]string{cfg.From}, cfg.To...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return nil, fmt.Errorf("invalid address: %q", addr)
+		}
+	}
+	subject, err := parseTemplate("subject", cfg.Subject, defaultSubject)
+	if err != nil {
+		return nil, err
+	}
+	body, err := parseTemplate("body", cfg.Template, defaultBody)
+	if err != nil {
+		return nil, err
+	}
+	m := &email{
+		addr:      cfg.SMTPAddr,
+		host:      host,
+		tls:       cfg.TLS,
+		tlsConfig: &tls.Config{ServerName: host},
+		from:      cfg.From,
+		to:        cfg.To,
+		subject:   subject,
+		body:      body,
+		timeout:   timeout,
+	}
+	if cfg.User != "" {
+		m.auth = smtp.PlainAuth("", cfg.User, cfg.Password, host)
+	}
+	return m, nil
+}
+
+func (m *email) send(e alerts.Event) error {
+	subject, err := render(m.subject, e)
+	if err != nil {
+		return err
+	}
+	body, err := render(m.body, e)
+	if err != nil {
+		return err
+	}
+	return m.deliver(m.message(subject, body, time.Now()))
+}
+
+func (m *email) message(subject, body string, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.to, ", "))
+	// Subjects come from templates and may contain anything, including
+	// line breaks that would inject headers.
+	subject = strings.Join(strings.Fields(subject), " ")
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+func (m *email) deliver(msg []byte) error {
+	dialer := &net.Dialer{Timeout: m.timeout}
+	var conn net.Conn
+	var err error
+	if m.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.addr, m.tlsConfig.Clone())
+	} else {
+		conn, err = dialer.Dial("tcp", m.addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(m.timeout))
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if !m.tls {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(m.tlsConfig.Clone()); err != nil {
+				return err
+			}
+		}
+	}
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return permanentError{err}
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	for _, rcpt := range m.to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
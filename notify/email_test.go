@@ -0,0 +1,234 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what the fake server saw during one connection.
+type smtpSession struct {
+	tls  bool
+	auth string
+	from string
+	to   []string
+	data string
+	// authTLS and dataTLS tell whether AUTH and DATA came over TLS.
+	authTLS bool
+	dataTLS bool
+}
+
+// fakeSMTP serves one SMTP session on 127.0.0.1 and reports it on the
+// returned channel. It offers STARTTLS with cfg when cfg is not nil.
+func fakeSMTP(t *testing.T, cfg *tls.Config) (string, <-chan smtpSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		sessions <- serveSMTP(conn, cfg)
+	}()
+	return ln.Addr().String(), sessions
+}
+
+func serveSMTP(conn net.Conn, cfg *tls.Config) smtpSession {
+	var s smtpSession
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return s
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			ext := []string{"fake", "AUTH PLAIN"}
+			if cfg != nil && !s.tls {
+				ext = append(ext, "STARTTLS")
+			}
+			for i, e := range ext {
+				sep := "-"
+				if i == len(ext)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, e)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 ready")
+			tc := tls.Server(conn, cfg)
+			if err := tc.Handshake(); err != nil {
+				return s
+			}
+			s.tls = true
+			tp = textproto.NewConn(tc)
+		case "AUTH":
+			mech, resp, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(resp)
+			if mech != "PLAIN" {
+				tp.PrintfLine("504 unsupported")
+				continue
+			}
+			s.auth, s.authTLS = string(decoded), s.tls
+			tp.PrintfLine("235 ok")
+		case "MAIL":
+			s.from = arg
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			s.to = append(s.to, arg)
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return s
+			}
+			s.data, s.dataTLS = string(data), s.tls
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return s
+		default:
+			tp.PrintfLine("500 unknown command")
+		}
+	}
+}
+
+// testTLS returns a server config for 127.0.0.1 and a client config that
+// trusts it.
+func testTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	defer srv.Close()
+	client = srv.Client().Transport.(*http.Transport).TLSClientConfig
+	return &tls.Config{Certificates: srv.TLS.Certificates}, client
+}
+
+func emailChannel(t *testing.T, cfg Config, clientTLS *tls.Config) *Channel {
+	t.Helper()
+	cfg.Name, cfg.Type, cfg.Retries = "mail", TypeEmail, -1
+	if cfg.From == "" {
+		cfg.From = "mon@example.com"
+	}
+	if cfg.To == nil {
+		cfg.To = []string{"ops@example.com"}
+	}
+	ch, err := NewChannel(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientTLS != nil {
+		m := ch.sender.(*email)
+		m.tlsConfig.RootCAs = clientTLS.RootCAs
+	}
+	return ch
+}
+
+func TestEmailStartTLSAndAuth(t *testing.T) {
+	serverTLS, clientTLS := testTLS(t)
+	addr, sessions := fakeSMTP(t, serverTLS)
+	ch := emailChannel(t, Config{SMTPAddr: addr, User: "mon", Password: "s3cret"}, clientTLS)
+	if err := ch.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	s := <-sessions
+	if !s.tls || !s.authTLS || !s.dataTLS {
+		t.Errorf("tls = %v, auth over tls = %v, data over tls = %v, want all true", s.tls, s.authTLS, s.dataTLS)
+	}
+	if want := "\x00mon\x00s3cret"; s.auth != want {
+		t.Errorf("auth = %q, want %q", s.auth, want)
+	}
+	if s.from != "FROM:<mon@example.com>" {
+		t.Errorf("from = %q", s.from)
+	}
+	if len(s.to) != 1 || s.to[0] != "TO:<ops@example.com>" {
+		t.Errorf("to = %q", s.to)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(s.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Subject"); got != "[mon] web-01 offline" {
+		t.Errorf("subject = %q", got)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	if !strings.HasPrefix(string(body), "web-01 is offline\n\nHost:      web-01\n") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestEmailRejectsUntrustedCertificate(t *testing.T) {
+	serverTLS, _ := testTLS(t)
+	addr, _ := fakeSMTP(t, serverTLS)
+	ch := emailChannel(t, Config{SMTPAddr: addr, User: "mon", Password: "s3cret"}, nil)
+	if err := ch.Notify(testEvent); err == nil {
+		t.Error("Notify succeeded with an untrusted certificate")
+	}
+}
+
+func TestEmailSubjectHeaderInjection(t *testing.T) {
+	addr, sessions := fakeSMTP(t, nil)
+	ch := emailChannel(t, Config{SMTPAddr: addr, Subject: "{{.Message}}"}, nil)
+	e := testEvent
+	e.Message = "down\r\nBcc: victim@example.com\r\n\r\nforged body"
+	if err := ch.Notify(e); err != nil {
+		t.Fatal(err)
+	}
+
+	s := <-sessions
+	if len(s.to) != 1 {
+		t.Errorf("to = %q, want one recipient", s.to)
+	}
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(s.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcc := msg.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("injected Bcc header: %q", bcc)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "down Bcc: victim@example.com forged body"; subject != want {
+		t.Errorf("subject = %q, want %q", subject, want)
+	}
+}
+
+func TestEmailConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{SMTPAddr: "localhost", From: "a@example.com", To: []string{"b@example.com"}},
+		{SMTPAddr: "localhost:25", To: []string{"b@example.com"}},
+		{SMTPAddr: "localhost:25", From: "a@example.com"},
+		{SMTPAddr: "localhost:25", From: "a@example.com\r\nBcc: c@example.com", To: []string{"b@example.com"}},
+		{SMTPAddr: "localhost:25", From: "a@example.com", To: []string{"b@example.com\nc@example.com"}},
+	} {
+		cfg.Name, cfg.Type = "mail", TypeEmail
+		if _, err := NewChannel(cfg, nil); err == nil {
+			t.Errorf("accepted %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stupidrun/mon/alerts"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Templates see the alerts.Event being sent, e.g. {{.Host}} or
+// {{.Alert.Value}}, plus these helpers:
+//
+//	json     encodes a value as JSON, for building webhook bodies
+//	time     formats a unix timestamp as RFC 3339
+//	upper    upper-cases a string
+var funcs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"time": func(ts int64) string {
+		if ts == 0 {
+			return ""
+		}
+		return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+	},
+	"upper": strings.ToUpper,
+}
+
+const (
+	defaultText    = `[{{upper .Severity}}] {{.Message}}`
+	defaultSubject = `[mon] {{.Host}} {{.Kind}}`
+	defaultBody    = `{{.Message}}
+
+Host:      {{.Host}}
+Event:     {{.Kind}}
+Severity:  {{.Severity}}
+Time:      {{time .Time}}
+{{- if .LastSeen}}
+Last seen: {{time .LastSeen}}{{end}}
+{{- with .Alert}}
+Rule:      {{.RuleName}}
+Value:     {{.Value}} (threshold {{.Threshold}}){{end}}
+`
+)
+
+func parseTemplate(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	t, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s template: %w", name, err)
+	}
+	return t, nil
+}
+
+func render(t *template.Template, e alerts.Event) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, e); err != nil {
+		return "", permanentError{fmt.Errorf("render %s template: %w", t.Name(), err)}
+	}
+	return buf.String(), nil
+}
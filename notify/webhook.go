@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stupidrun/mon/alerts"
+	"io"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// webhook POSTs a JSON body to URL. Without a template the body is the event
+// itself; a template must render valid JSON.
+type webhook struct {
+	url     string
+	headers map[string]string
+	tmpl    *template.Template
+	client  *http.Client
+}
+
+func newWebhook(cfg Config, timeout time.Duration) (*webhook, error) {
+	if err := checkURL(cfg.URL); err != nil {
+		return nil, err
+	}
+	w := &webhook{
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: timeout},
+	}
+	if cfg.Template != "" {
+		t, err := parseTemplate("body", cfg.Template, "")
+		if err != nil {
+			return nil, err
+		}
+		w.tmpl = t
+	}
+	return w, nil
+}
+
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url: %q", raw)
+	}
+	return nil
+}
+
+func (w *webhook) send(e alerts.Event) error {
+	var body []byte
+	if w.tmpl == nil {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return permanentError{err}
+		}
+		body = b
+	} else {
+		s, err := render(w.tmpl, e)
+		if err != nil {
+			return err
+		}
+		if !json.Valid([]byte(s)) {
+			return permanentError{errors.New("body template did not render valid JSON")}
+		}
+		body = []byte(s)
+	}
+	return post(w.client, w.url, w.headers, body)
+}
+
+// slack posts to a Slack incoming webhook. Mattermost and Rocket.Chat accept
+// the same payload.
+type slack struct {
+	url     string
+	payload slackPayload
+	tmpl    *template.Template
+	client  *http.Client
+}
+
+type slackPayload struct {
+	Text      string `json:"text"`
+	Channel   string `json:"channel,omitempty"`
+	Username  string `json:"username,omitempty"`
+	IconEmoji string `json:"icon_emoji,omitempty"`
+}
+
+func newSlack(cfg Config, timeout time.Duration) (*slack, error) {
+	if err := checkURL(cfg.URL); err != nil {
+		return nil, err
+	}
+	t, err := parseTemplate("text", cfg.Template, defaultText)
+	if err != nil {
+		return nil, err
+	}
+	return &slack{
+		url: cfg.URL,
+		payload: slackPayload{
+			Channel:   cfg.Channel,
+			Username:  cfg.Username,
+			IconEmoji: cfg.IconEmoji,
+		},
+		tmpl:   t,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *slack) send(e alerts.Event) error {
+	text, err := render(s.tmpl, e)
+	if err != nil {
+		return err
+	}
+	p := s.payload
+	p.Text = text
+	body, err := json.Marshal(p)
+	if err != nil {
+		return permanentError{err}
+	}
+	return post(s.client, s.url, nil, body)
+}
+
+// post sends body as JSON. Client errors other than 429 are permanent.
+func post(client *http.Client, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
@@ -0,0 +1,208 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stupidrun/mon/alerts"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testEvent = alerts.Event{
+	Kind:     alerts.EventOffline,
+	Host:     "web-01",
+	Time:     1700000000,
+	Severity: "critical",
+	Message:  "web-01 is offline",
+}
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// recorder serves the given statuses in turn, repeating the last one, and
+// records every request.
+func recorder(t *testing.T, statuses ...int) (*httptest.Server, chan request, *atomic.Int32) {
+	t.Helper()
+	requests := make(chan request, 10)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{header: r.Header.Clone(), body: body}
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests, &calls
+}
+
+func TestWebhookSendsEvent(t *testing.T) {
+	srv, requests, _ := recorder(t, http.StatusOK)
+	ch, err := NewChannel(Config{
+		Name:    "hook",
+		Type:    TypeWebhook,
+		URL:     srv.URL,
+		Headers: map[string]string{"X-Token": "secret"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-requests
+	if got := r.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := r.header.Get("X-Token"); got != "secret" {
+		t.Errorf("X-Token = %q", got)
+	}
+	var e alerts.Event
+	if err := json.Unmarshal(r.body, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e != testEvent {
+		t.Errorf("body = %+v, want %+v", e, testEvent)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	srv, requests, _ := recorder(t, http.StatusOK)
+	ch, err := NewChannel(Config{
+		Name:     "hook",
+		Type:     TypeWebhook,
+		URL:      srv.URL,
+		Template: `{"host": {{json .Host}}, "at": {{json (time .Time)}}}`,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if want := `{"host": "web-01", "at": "2023-11-14T22:13:20Z"}`; string(r.body) != want {
+		t.Errorf("body = %s, want %s", r.body, want)
+	}
+
+	ch, err = NewChannel(Config{Name: "hook", Type: TypeWebhook, URL: srv.URL, Template: `not json {{.Host}}`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var perm permanentError
+	if err := ch.Notify(testEvent); !errors.As(err, &perm) {
+		t.Errorf("Notify = %v, want a permanent error", err)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	srv, _, calls := recorder(t, http.StatusServiceUnavailable, http.StatusOK)
+	ch, err := NewChannel(Config{Name: "hook", Type: TypeWebhook, URL: srv.URL, Retries: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d calls, want 2", n)
+	}
+}
+
+func TestWebhookClientErrorIsNotRetried(t *testing.T) {
+	srv, _, calls := recorder(t, http.StatusBadRequest)
+	ch, err := NewChannel(Config{Name: "hook", Type: TypeWebhook, URL: srv.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Notify(testEvent); err == nil {
+		t.Fatal("Notify succeeded on 400")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d calls, want 1", n)
+	}
+}
+
+type denyAll struct{}
+
+func (denyAll) Allow(string) (bool, time.Duration) { return false, time.Minute }
+
+func TestRateLimitedChannelDoesNotSend(t *testing.T) {
+	srv, _, calls := recorder(t, http.StatusOK)
+	ch, err := NewChannel(Config{Name: "hook", Type: TypeWebhook, URL: srv.URL}, denyAll{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Notify(testEvent); err == nil {
+		t.Error("Notify succeeded over the rate limit")
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("%d calls, want 0", n)
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	srv, requests, _ := recorder(t, http.StatusOK)
+	ch, err := NewChannel(Config{
+		Name:      "chat",
+		Type:      TypeSlack,
+		URL:       srv.URL,
+		Channel:   "#ops",
+		Username:  "mon",
+		IconEmoji: ":rotating_light:",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	var p slackPayload
+	if err := json.Unmarshal((<-requests).body, &p); err != nil {
+		t.Fatal(err)
+	}
+	want := slackPayload{
+		Text:      "[CRITICAL] web-01 is offline",
+		Channel:   "#ops",
+		Username:  "mon",
+		IconEmoji: ":rotating_light:",
+	}
+	if p != want {
+		t.Errorf("payload = %+v, want %+v", p, want)
+	}
+}
+
+func TestSlackTemplate(t *testing.T) {
+	srv, requests, _ := recorder(t, http.StatusOK)
+	ch, err := NewChannel(Config{Name: "chat", Type: TypeSlack, URL: srv.URL, Template: `{{.Host}} went {{.Kind}}`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var p slackPayload
+	if err := json.Unmarshal((<-requests).body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if want := "web-01 went offline"; p.Text != want {
+		t.Errorf("text = %q, want %q", p.Text, want)
+	}
+}
+
+func TestInvalidURL(t *testing.T) {
+	for _, typ := range []string{TypeWebhook, TypeSlack} {
+		for _, url := range []string{"", "ftp://example.com", "http://"} {
+			if _, err := NewChannel(Config{Name: "x", Type: typ, URL: url}, nil); err == nil {
+				t.Errorf("%s channel accepted url %q", typ, url)
+			}
+		}
+	}
+}
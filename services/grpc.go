@@ -1,24 +1,37 @@
 package services
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"github.com/stupidrun/mon/alerts"
 	"github.com/stupidrun/mon/api/middlewares"
 	"github.com/stupidrun/mon/api/proto"
 	"github.com/stupidrun/mon/models"
+	"github.com/stupidrun/mon/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
+	"maps"
+	"math"
+	"net/netip"
+	"slices"
 )
 
 type MonitoringService struct {
 	proto.UnimplementedMonitoringServiceServer
-	store *models.MetricsStore
-	debug bool
+	store  models.Store
+	ips    *models.IPTracker
+	alerts *alerts.Engine
+	debug  bool
 }
 
-func NewMonitoringService(store *models.MetricsStore, debug bool) *MonitoringService {
+func NewMonitoringService(store models.Store, ips *models.IPTracker, alertEngine *alerts.Engine, debug bool) *MonitoringService {
 	return &MonitoringService{
-		store: store,
-		debug: debug,
+		store:  store,
+		ips:    ips,
+		alerts: alertEngine,
+		debug:  debug,
 	}
 }
 
@@ -27,22 +40,54 @@ func (s *MonitoringService) PushMetrics(ctx context.Context, req *proto.PushMetr
 	if !ok {
 		return nil, errors.New("could not extract client IP from context")
 	}
-	for _, metric := range req.Metrics {
+	// Check every metric before storing anything, so that a batch is either
+	// rejected as a whole or has been rate limited as a whole.
+	ips := make([]string, len(req.Metrics))
+	for i, metric := range req.Metrics {
 		if metric.Name == "" {
 			return nil, errors.New("metric name cannot be empty")
 		}
+		if !middlewares.PeerIdentityMatches(ctx, metric.Name) {
+			log.Printf("security: rejected metrics for %s from %s: name does not match client certificate", metric.Name, clientIP)
+			return nil, status.Errorf(codes.PermissionDenied, "metric name %q does not match client certificate", metric.Name)
+		}
+		if !middlewares.AgentNameMatches(ctx, metric.Name) {
+			log.Printf("security: rejected metrics for %s from %s: name does not match agent token", metric.Name, clientIP)
+			return nil, status.Errorf(codes.PermissionDenied, "metric name %q does not match agent token", metric.Name)
+		}
+		ip := clientIP
+		if metric.Address != "" && middlewares.ReportedAddressTrusted(ctx) {
+			if addr, err := netip.ParseAddr(metric.Address); err == nil {
+				ip = addr.Unmap().String()
+			}
+		}
+		if s.store.IsAllowed(metric.Name) && !models.CIDRsContain(s.store.AllowedCIDRs(metric.Name), ip) {
+			log.Printf("security: rejected metrics for %s from %s: address not in allowed CIDRs", metric.Name, ip)
+			return nil, status.Errorf(codes.PermissionDenied, "address %s is not allowed to push %q", ip, metric.Name)
+		}
+		ips[i] = ip
+	}
+	for i, metric := range req.Metrics {
+		ip := ips[i]
 		if !s.store.IsAllowed(metric.Name) {
 			continue
 		}
-		s.store.AddMetric(metric.Name, models.Metric{
+		if s.ips.Observe(metric.Name, ip) {
+			status, _ := s.ips.Status(metric.Name)
+			log.Printf("security: %s is pushed from several addresses: %v", metric.Name, slices.Sorted(maps.Keys(status.IPs)))
+		}
+		m := models.Metric{
 			Name:        metric.Name,
-			IP:          clientIP,
+			IP:          ip,
 			CPUUsage:    metric.CpuUsage,
 			MemoryUsage: metric.MemoryUsage,
 			NetworkIn:   metric.NetworkIn,
 			NetworkOut:  metric.NetworkOut,
 			Timestamp:   metric.Timestamp,
-		})
+			Samples:     toSamples(metric.Samples),
+		}
+		s.store.AddMetric(metric.Name, m)
+		s.alerts.Observe(m)
 	}
 	if s.debug {
 		log.Printf("Received metrics from %s: %v", clientIP, req.Metrics)
@@ -53,3 +98,83 @@ func (s *MonitoringService) PushMetrics(ctx context.Context, req *proto.PushMetr
 		Message: "Metrics pushed successfully",
 	}, nil
 }
+
+func toSamples(samples []*proto.Sample) []models.Sample {
+	if len(samples) == 0 {
+		return nil
+	}
+	result := make([]models.Sample, 0, len(samples))
+	for _, sample := range samples {
+		if sample.Name == "" {
+			continue
+		}
+		if !utils.FiniteSample(sample) {
+			log.Printf("dropping sample %s: non-finite value", sample.Name)
+			continue
+		}
+		s := models.Sample{
+			Name:   sample.Name,
+			Value:  sample.Value,
+			Type:   models.SampleGauge,
+			Labels: sample.Labels,
+		}
+		switch sample.Type {
+		case proto.SampleType_COUNTER:
+			s.Type = models.SampleCounter
+		case proto.SampleType_HISTOGRAM:
+			if sample.Histogram == nil {
+				continue
+			}
+			s.Type = models.SampleHistogram
+			s.Histogram = toHistogram(sample.Histogram)
+		case proto.SampleType_SUMMARY:
+			if sample.Summary == nil {
+				continue
+			}
+			s.Type = models.SampleSummary
+			s.Summary = toSummary(sample.Summary)
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func toHistogram(h *proto.Histogram) *models.Histogram {
+	result := &models.Histogram{
+		Buckets: make([]models.Bucket, 0, len(h.Buckets)),
+		Sum:     h.Sum,
+		Count:   h.Count,
+	}
+	for _, b := range h.Buckets {
+		// The +Inf bucket is implied by Count and cannot be encoded as JSON.
+		if math.IsInf(b.UpperBound, 0) || math.IsNaN(b.UpperBound) {
+			continue
+		}
+		result.Buckets = append(result.Buckets, models.Bucket{
+			UpperBound: b.UpperBound,
+			Count:      b.Count,
+		})
+	}
+	slices.SortFunc(result.Buckets, func(a, b models.Bucket) int {
+		return cmp.Compare(a.UpperBound, b.UpperBound)
+	})
+	return result
+}
+
+func toSummary(s *proto.Summary) *models.Summary {
+	result := &models.Summary{
+		Quantiles: make([]models.Quantile, 0, len(s.Quantiles)),
+		Sum:       s.Sum,
+		Count:     s.Count,
+	}
+	for _, q := range s.Quantiles {
+		if math.IsNaN(q.Quantile) || math.IsNaN(q.Value) || math.IsInf(q.Value, 0) {
+			continue
+		}
+		result.Quantiles = append(result.Quantiles, models.Quantile{
+			Quantile: q.Quantile,
+			Value:    q.Value,
+		})
+	}
+	return result
+}
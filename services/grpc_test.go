@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"github.com/stupidrun/mon/alerts"
+	"github.com/stupidrun/mon/api/middlewares"
+	"github.com/stupidrun/mon/api/proto"
+	"github.com/stupidrun/mon/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// push calls PushMetrics as a client connected from ip.
+func push(t *testing.T, s *MonitoringService, ip string, req *proto.PushMetricsRequest) error {
+	t.Helper()
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+	_, err := middlewares.IPExtractorInterceptor(nil)(ctx, req, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		return s.PushMetrics(ctx, req.(*proto.PushMetricsRequest))
+	})
+	return err
+}
+
+func TestPushMetricsRejectsBatchOnCIDR(t *testing.T) {
+	store := models.NewMetricsStore(models.DefaultRetention())
+	store.AddAllowedName("web-01")
+	store.AddAllowedName("web-02")
+	store.SetAllowedCIDRs("web-02", []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")})
+	engine, err := alerts.NewEngine(alerts.NewRuleFile(filepath.Join(t.TempDir(), "rules.json")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := models.NewIPTracker(time.Hour)
+	s := NewMonitoringService(store, ips, engine, false)
+
+	err = push(t, s, "10.0.0.5", &proto.PushMetricsRequest{Metrics: []*proto.Metric{
+		{Name: "web-01", Timestamp: 1, CpuUsage: 10},
+		{Name: "web-02", Timestamp: 1, CpuUsage: 20},
+	}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("PushMetrics = %v, want PermissionDenied", err)
+	}
+	for _, name := range []string{"web-01", "web-02"} {
+		if got := store.GetMetrics(name); len(got) != 0 {
+			t.Errorf("%s stored %d metrics from a rejected batch", name, len(got))
+		}
+		if _, ok := ips.Status(name); ok {
+			t.Errorf("%s address recorded from a rejected batch", name)
+		}
+	}
+}
@@ -1,11 +1,17 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/net"
 	"github.com/stupidrun/mon/api/proto"
+	"log"
+	"math"
+	stdnet "net"
 	"time"
 )
 
@@ -26,15 +32,76 @@ func GetCurrentMetrics(threshold int) (*proto.Metric, error) {
 		return nil, fmt.Errorf("获取网络流量失败: %w", err)
 	}
 
+	// 扩展指标是可选的, 读取失败时仍然推送固定字段
+	samples, err := GetSamples()
+	if err != nil {
+		log.Printf("获取扩展指标失败: %v", err)
+	}
+
 	return &proto.Metric{
 		CpuUsage:    cpuUsage,
 		MemoryUsage: memUsage,
 		NetworkIn:   netIn,
 		NetworkOut:  netOut,
 		Timestamp:   time.Now().UTC().Unix(),
+		Samples:     samples,
 	}, nil
 }
 
+// GetSamples 获取固定字段之外的扩展指标(系统负载、磁盘使用率),
+// 出错时仍返回已读取到的部分
+func GetSamples() ([]*proto.Sample, error) {
+	var samples []*proto.Sample
+	avg, loadErr := load.Avg()
+	if loadErr == nil {
+		samples = append(samples,
+			&proto.Sample{Name: "load1", Value: avg.Load1},
+			&proto.Sample{Name: "load5", Value: avg.Load5},
+			&proto.Sample{Name: "load15", Value: avg.Load15},
+		)
+	} else {
+		loadErr = fmt.Errorf("系统负载: %w", loadErr)
+	}
+
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return samples, errors.Join(loadErr, fmt.Errorf("磁盘分区: %w", err))
+	}
+	for _, p := range partitions {
+		usage, err := disk.Usage(p.Mountpoint)
+		if err != nil {
+			// 部分挂载点(如无权限访问的)无法读取, 跳过即可
+			continue
+		}
+		samples = append(samples, &proto.Sample{
+			Name:   "disk_used_percent",
+			Value:  usage.UsedPercent,
+			Labels: map[string]string{"mountpoint": p.Mountpoint},
+		})
+	}
+	return samples, loadErr
+}
+
+// FiniteSample 检查指标中的数值是否都是有限值, NaN 和 Inf 无法编码为 JSON,
+// 也会破坏服务端的聚合计算. 直方图的 +Inf 桶和摘要中的 NaN 分位值是合法的,
+// 由服务端跳过
+func FiniteSample(s *proto.Sample) bool {
+	if !finite(s.Value) {
+		return false
+	}
+	if s.Histogram != nil && !finite(s.Histogram.Sum) {
+		return false
+	}
+	if s.Summary != nil && !finite(s.Summary.Sum) {
+		return false
+	}
+	return true
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
 // GetCPUUsage 获取CPU使用率(百分比)
 func GetCPUUsage() (float64, error) {
 	percentages, err := cpu.Percent(time.Second, false)
@@ -87,3 +154,13 @@ func GetNetworkIO(threshold int) (float64, float64, error) {
 
 	return netIn, netOut, nil
 }
+
+// GetOutboundIP 返回访问 target 时使用的本机地址, 仅做路由查询, 不会发送数据
+func GetOutboundIP(target string) (string, error) {
+	conn, err := stdnet.Dial("udp", target)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*stdnet.UDPAddr).IP.String(), nil
+}